@@ -2,9 +2,12 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -39,10 +42,18 @@ type Indexes []Index
 
 func createIndex(coll *mongo.Collection, indexes Indexes) {
 	for _, idx := range indexes {
+		// comma separated keys create a compound index
+		keys := bson.D{}
+		for _, key := range strings.Split(idx.Keys, ",") {
+			keys = append(keys, bson.E{Key: key, Value: 1})
+		}
 		indexName, err := coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
-			Keys:    bson.M{idx.Keys: 1},
+			Keys:    keys,
 			Options: idx.Options,
 		})
+		if isIndexOptionsConflict(err) && idx.Options != nil && idx.Options.ExpireAfterSeconds != nil {
+			indexName, err = updateExpiry(coll, keys, *idx.Options.ExpireAfterSeconds)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -50,6 +61,47 @@ func createIndex(coll *mongo.Collection, indexes Indexes) {
 	}
 }
 
+func isIndexOptionsConflict(err error) bool {
+	var commandErr mongo.CommandError
+	return errors.As(err, &commandErr) && commandErr.Name == "IndexOptionsConflict"
+}
+
+// updateExpiry changes the expiry of an existing TTL index in place, as
+// creating it again with another expiry is rejected.
+func updateExpiry(coll *mongo.Collection, keys bson.D, seconds int32) (string, error) {
+	err := coll.Database().RunCommand(context.TODO(), bson.D{
+		{Key: "collMod", Value: coll.Name()},
+		{Key: "index", Value: bson.D{
+			{Key: "keyPattern", Value: keys},
+			{Key: "expireAfterSeconds", Value: seconds},
+		}},
+	}).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to update expiry of index on %s: %w", coll.Name(), err)
+	}
+
+	// the default name of an ascending index, e.g. read_at_1
+	var name []string
+	for _, key := range keys {
+		name = append(name, key.Key+"_1")
+	}
+
+	return strings.Join(name, "_"), nil
+}
+
+const defaultReadNotificationsDays = 90
+
+// readNotificationsTTL is how long read notifications are kept, in seconds,
+// read from NOTIFICATIONS_READ_TTL_DAYS.
+func readNotificationsTTL() int32 {
+	days, err := strconv.Atoi(os.Getenv("NOTIFICATIONS_READ_TTL_DAYS"))
+	if err != nil || days <= 0 {
+		days = defaultReadNotificationsDays
+	}
+
+	return int32(days * 24 * 60 * 60)
+}
+
 func initIndexes() {
 	indexes := Indexes{
 		{
@@ -70,6 +122,15 @@ func initIndexes() {
 		{
 			Keys: "job",
 		},
+		{
+			Keys: "user_credentials.email",
+		},
+		{
+			Keys: "email_deliverability.status",
+		},
+		{
+			Keys: "digest_frequency,last_digest_at",
+		},
 	}
 	researchIndexes := Indexes{
 		{
@@ -89,6 +150,15 @@ func initIndexes() {
 		{
 			Keys: "location",
 		},
+		{
+			Keys: "series_id,original_date",
+		},
+		{
+			Keys: "date",
+		},
+		{
+			Keys: "recurrence,date",
+		},
 	}...)
 
 	projectIndexes := append(indexes, Indexes{
@@ -116,6 +186,133 @@ func initIndexes() {
 	createIndex(verificationDataCollection, verificationIndexes)
 }
 
+// ensureIndexes creates the indexes the application relies on to stay
+// consistent, i.e. unique keys that guard against concurrent writes and
+// expiry of short-lived documents. Unlike initIndexes it runs on every start.
+func ensureIndexes() {
+	usersIndexes := Indexes{
+		{
+			Keys:    "calendar_token",
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+	}
+	filesIndexes := Indexes{
+		{
+			Keys: "urls",
+		},
+		{
+			Keys: "references.document",
+		},
+		{
+			Keys: "unreferenced_at",
+		},
+		{
+			Keys:    "hash,purpose",
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: "owners",
+		},
+		{
+			Keys: "status",
+		},
+	}
+	outboxIndexes := Indexes{
+		{
+			Keys:    "key",
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: "status,next_attempt_at",
+		},
+		{
+			Keys: "created_at",
+		},
+	}
+	uploadCountsIndexes := Indexes{
+		{
+			Keys:    "user_id,day",
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    "expires_at",
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	notificationsIndexes := Indexes{
+		{
+			Keys: "user_id,status,created_at",
+		},
+		{
+			Keys: "user_id,_id",
+		},
+		{
+			// read notifications are removed once the retention period ends
+			Keys:    "read_at",
+			Options: options.Index().SetExpireAfterSeconds(readNotificationsTTL()),
+		},
+	}
+	pushSubscriptionsIndexes := Indexes{
+		{
+			Keys:    "endpoint",
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: "user_id",
+		},
+	}
+	eventRegistrationsIndexes := Indexes{
+		{
+			Keys:    "event_id,user_id",
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: "event_id,status,updated_at",
+		},
+		{
+			Keys: "user_id",
+		},
+	}
+	certificatesIndexes := Indexes{
+		{
+			Keys:    "code",
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    "event_id,user_id",
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	scheduledJobsIndexes := Indexes{
+		{
+			Keys:    "key",
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: "status,run_at",
+		},
+	}
+
+	users, _ := GetCollection("users")
+	createIndex(users, usersIndexes)
+	files, _ := GetCollection("files")
+	createIndex(files, filesIndexes)
+	uploadCounts, _ := GetCollection("upload_counts")
+	createIndex(uploadCounts, uploadCountsIndexes)
+	notificationsCollection, _ := GetCollection("notifications")
+	createIndex(notificationsCollection, notificationsIndexes)
+	outbox, _ := GetCollection("email_outbox")
+	createIndex(outbox, outboxIndexes)
+	pushSubscriptions, _ := GetCollection("push_subscriptions")
+	createIndex(pushSubscriptions, pushSubscriptionsIndexes)
+	eventRegistrations, _ := GetCollection("event_registrations")
+	createIndex(eventRegistrations, eventRegistrationsIndexes)
+	scheduledJobs, _ := GetCollection("scheduled_jobs")
+	createIndex(scheduledJobs, scheduledJobsIndexes)
+	certificates, _ := GetCollection("certificates")
+	createIndex(certificates, certificatesIndexes)
+}
+
 func InitDb() {
 	clientOptions := GetClientOptions()
 
@@ -125,6 +322,7 @@ func InitDb() {
 	} else {
 		client = newClient
 		// initIndexes()
+		ensureIndexes()
 	}
 }
 
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration is a one-off data change. Applied migrations are recorded by
+// name in the migrations collection and never run again.
+type Migration struct {
+	Name string
+	Up   func(ctx context.Context) error
+}
+
+// RunMigrations applies the migrations that have not run yet, in order.
+func RunMigrations(ctx context.Context, migrations ...Migration) error {
+	collection, _ := GetCollection("migrations")
+
+	for _, migration := range migrations {
+		err := collection.FindOne(ctx, bson.M{"_id": migration.Name}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return fmt.Errorf("failed to check migration %s: %w", migration.Name, err)
+		}
+
+		if err := migration.Up(ctx); err != nil {
+			return fmt.Errorf("migration %s failed: %w", migration.Name, err)
+		}
+
+		_, err = collection.InsertOne(ctx, bson.M{"_id": migration.Name, "applied_at": time.Now()})
+		if err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", migration.Name, err)
+		}
+		log.Printf("migration %s applied", migration.Name)
+	}
+
+	return nil
+}
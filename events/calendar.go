@@ -0,0 +1,297 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/email"
+	"henar-backend/internal/ical"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"henar-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	eventsURL = "https://healthnet.am/events/"
+
+	// past events stay in feeds for a while so calendars don't drop them
+	// right after they happen
+	feedHistory = 30 * 24 * time.Hour
+	feedLimit   = 1000
+)
+
+// calendarLanguage is the language of calendar texts: the language query
+// parameter, then the Accept-Language header.
+func calendarLanguage(c *fiber.Ctx) string {
+	if language := c.Query("language"); language != "" {
+		return email.Language(language)
+	}
+
+	accepted, _, _ := strings.Cut(c.Get(fiber.HeaderAcceptLanguage), ",")
+	return email.Language(accepted)
+}
+
+// calendar builds an iCalendar of the events with texts in the given
+// language.
+func calendar(ctx context.Context, name string, events []types.Event, language string) (ical.Calendar, error) {
+	locationIds := []primitive.ObjectID{}
+	for _, event := range events {
+		if !event.Location.IsZero() {
+			locationIds = append(locationIds, event.Location)
+		}
+	}
+
+	places := map[primitive.ObjectID]string{}
+	if len(locationIds) > 0 {
+		collection, _ := db.GetCollection("locations")
+		cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": locationIds}})
+		if err != nil {
+			return ical.Calendar{}, fmt.Errorf("failed to find event locations: %w", err)
+		}
+
+		var locations []types.Location
+		if err := cursor.All(ctx, &locations); err != nil {
+			return ical.Calendar{}, fmt.Errorf("failed to decode event locations: %w", err)
+		}
+		for _, location := range locations {
+			places[location.ID] = location.Value
+		}
+	}
+
+	cal := ical.Calendar{Name: name}
+	for _, event := range events {
+		entry := ical.Event{
+			UID:         event.ID.Hex() + "@healthnet.am",
+			Summary:     event.Title.Localized(language),
+			Description: event.Description.Localized(language),
+			Location:    places[event.Location],
+			URL:         eventsURL + event.Slug,
+			Start:       event.Date,
+			Status:      ical.StatusConfirmed,
+		}
+		if event.Recurrence != nil {
+			entry.RRule = event.Recurrence.RRule
+			entry.ExDates = event.Recurrence.ExDates
+		}
+		// an edited occurrence replaces its original in the series
+		if event.SeriesID != nil && event.OriginalDate != nil {
+			entry.UID = event.SeriesID.Hex() + "@healthnet.am"
+			entry.RecurrenceID = *event.OriginalDate
+		}
+		cal.Events = append(cal.Events, entry)
+	}
+
+	return cal, nil
+}
+
+func sendCalendar(c *fiber.Ctx, cal ical.Calendar, filename string) error {
+	body, err := cal.Bytes()
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error encoding calendar")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	if filename != "" {
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	}
+
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// sendEventCalendar answers GetEvent with an .ics file.
+func sendEventCalendar(c *fiber.Ctx, event types.Event) error {
+	language := calendarLanguage(c)
+
+	cal, err := calendar(context.TODO(), event.Title.Localized(language), []types.Event{event}, language)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error building calendar")
+	}
+
+	return sendCalendar(c, cal, event.Slug+".ics")
+}
+
+// @Summary Get events calendar feed
+// @Description iCalendar feed of upcoming and recent events to subscribe to in calendar apps, filtered like the events list.
+// @Tags events
+// @Produce text/calendar
+// @Param language query string false "Language of titles and descriptions (en, ru, hy)"
+// @Param tags query string false "Comma-separated list of tag IDs to filter by"
+// @Param location query string false "Location ID to filter by"
+// @Success 200 {string} string "iCalendar feed"
+// @Failure 400 {string} string "Invalid filter"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/feed.ics [get]
+func GetEventsFeed(c *fiber.Ctx) error {
+	filter, err := utils.GetFilter(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Error getting filter: " + err.Error())
+	}
+	filter["$or"] = bson.A{
+		bson.M{"date": bson.M{"$gte": time.Now().Add(-feedHistory)}},
+		bson.M{"recurrence": bson.M{"$exists": true}},
+	}
+
+	collection, _ := db.GetCollection("events")
+	cursor, err := collection.Find(context.TODO(), filter,
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}}).SetLimit(feedLimit),
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding events")
+	}
+
+	var results []types.Event
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding events")
+	}
+
+	cal, err := calendar(context.TODO(), "HealthNet", results, calendarLanguage(c))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error building calendar")
+	}
+
+	return sendCalendar(c, cal, "")
+}
+
+// @Summary Get personal calendar feed
+// @Description iCalendar feed of the events the owner of the token is going to, in their language. The token comes from /v1/my-events/calendar.
+// @Tags events
+// @Produce text/calendar
+// @Param token path string true "Calendar token"
+// @Success 200 {string} string "iCalendar feed"
+// @Failure 404 {string} string "Calendar not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/calendar/{token} [get]
+func GetPersonalFeed(c *fiber.Ctx) error {
+	token := strings.TrimSuffix(c.Params("token"), ".ics")
+	if token == "" {
+		return c.Status(http.StatusNotFound).SendString("Calendar not found")
+	}
+
+	usersCollection, _ := db.GetCollection("users")
+	var user types.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"calendar_token": token}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).SendString("Calendar not found")
+		}
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding calendar")
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": user.ID, "status": types.RSVPGoing}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "events",
+			"localField":   "event_id",
+			"foreignField": "_id",
+			"as":           "event",
+		}}},
+		{{Key: "$unwind", Value: "$event"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$event"}}},
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"date": bson.M{"$gte": time.Now().Add(-feedHistory)}},
+			bson.M{"recurrence": bson.M{"$exists": true}},
+		}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: 1}}}},
+		{{Key: "$limit", Value: feedLimit}},
+	}
+
+	registrations, _ := db.GetCollection("event_registrations")
+	cursor, err := registrations.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding events")
+	}
+
+	var results []types.Event
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding events")
+	}
+
+	cal, err := calendar(context.TODO(), "HealthNet", results, email.Language(user.Language))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error building calendar")
+	}
+
+	return sendCalendar(c, cal, "")
+}
+
+// @Summary Get personal calendar link
+// @Description Returns the path of the current user's personal calendar feed, creating it on first use.
+// @Tags events
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/my-events/calendar [get]
+func GetPersonalFeedLink(c *fiber.Ctx) error {
+	return personalFeedLink(c, false)
+}
+
+// @Summary Reset personal calendar link
+// @Description Replaces the token of the current user's personal calendar feed. The old link stops working.
+// @Tags events
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/my-events/calendar/reset [post]
+func ResetPersonalFeedLink(c *fiber.Ctx) error {
+	return personalFeedLink(c, true)
+}
+
+func personalFeedLink(c *fiber.Ctx, reset bool) error {
+	userId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "not authorized"})
+	}
+
+	collection, _ := db.GetCollection("users")
+	var user types.User
+	err = collection.FindOne(context.TODO(), bson.M{"_id": userId},
+		options.FindOne().SetProjection(bson.M{"calendar_token": 1}),
+	).Decode(&user)
+	if err != nil {
+		sentry.SentryHandler(err)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).SendString("User not found")
+		}
+		return c.Status(http.StatusInternalServerError).SendString("Error retrieving user: " + err.Error())
+	}
+
+	token := user.CalendarToken
+	if token == "" || reset {
+		token, err = utils.RandomHex(32)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusInternalServerError).SendString("Error creating calendar token")
+		}
+
+		_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": userId}, bson.M{"$set": bson.M{"calendar_token": token}})
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusInternalServerError).SendString("Error updating user: " + err.Error())
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"token": token,
+		"path":  "/v1/events/calendar/" + token + ".ics",
+	})
+}
@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/certificate"
+	"henar-backend/internal/email"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"henar-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const certificatesURL = "https://healthnet.am/certificates/"
+
+func fullName(user types.User) string {
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// certificateCode is a random code grouped for reading out, e.g.
+// 3F9A-04C2-B71E.
+func certificateCode() (string, error) {
+	random, err := utils.RandomHex(6)
+	if err != nil {
+		return "", err
+	}
+	random = strings.ToUpper(random)
+
+	return random[0:4] + "-" + random[4:8] + "-" + random[8:12], nil
+}
+
+// organizer is who the event is run by: its organizations, or the name of
+// the user who created it.
+func organizer(ctx context.Context, event types.Event) (string, error) {
+	if orgs := strings.TrimSpace(event.Orgs); orgs != "" {
+		return orgs, nil
+	}
+
+	collection, _ := db.GetCollection("users")
+	var creator types.User
+	err := collection.FindOne(ctx, bson.M{"_id": event.CreatedBy},
+		options.FindOne().SetProjection(bson.M{"user_body.first_name": 1, "user_body.last_name": 1}),
+	).Decode(&creator)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find organizer of %s: %w", event.ID.Hex(), err)
+	}
+
+	return fullName(creator), nil
+}
+
+// issueCertificate returns the user's certificate for the event, issuing it
+// on first use.
+func issueCertificate(ctx context.Context, event types.Event, user types.User) (types.Certificate, error) {
+	collection, _ := db.GetCollection("certificates")
+	filter := bson.M{"event_id": event.ID, "user_id": user.ID}
+
+	var issued types.Certificate
+	err := collection.FindOne(ctx, filter).Decode(&issued)
+	if err == nil {
+		return issued, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return types.Certificate{}, fmt.Errorf("failed to find certificate: %w", err)
+	}
+
+	code, err := certificateCode()
+	if err != nil {
+		return types.Certificate{}, fmt.Errorf("failed to create certificate code: %w", err)
+	}
+	by, err := organizer(ctx, event)
+	if err != nil {
+		return types.Certificate{}, err
+	}
+
+	issued = types.Certificate{
+		ID:         primitive.NewObjectID(),
+		Code:       code,
+		Event:      event.ID,
+		User:       user.ID,
+		Name:       fullName(user),
+		EventTitle: event.Title,
+		EventDate:  event.Date,
+		Organizer:  by,
+		IssuedAt:   time.Now(),
+	}
+	_, err = collection.InsertOne(ctx, issued)
+	if mongo.IsDuplicateKeyError(err) {
+		// issued by a concurrent request
+		err = collection.FindOne(ctx, filter).Decode(&issued)
+	}
+	if err != nil {
+		return types.Certificate{}, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+
+	return issued, nil
+}
+
+// @Summary Get certificate of attendance
+// @Description Returns the current user's certificate of attendance as a PDF file. The organizer has to enable certificates for the event and the user has to be checked in. The certificate is issued on first download and keeps its verification code.
+// @Tags events
+// @Produce application/pdf
+// @Param id path string true "Event ID"
+// @Param language query string false "Language of the certificate (en, ru, hy), the user's language by default"
+// @Success 200 {file} file "Certificate"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 401 {string} string "not authorized"
+// @Failure 403 {string} string "Not checked in"
+// @Failure 404 {string} string "Event not found or certificates not enabled"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/{id}/certificate [get]
+func GetCertificate(c *fiber.Ctx) error {
+	userId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "not authorized"})
+	}
+
+	event, err := findEvent(c)
+	if event == nil {
+		return err
+	}
+	if event.Certificates == nil || !*event.Certificates {
+		return c.Status(http.StatusNotFound).SendString("Certificates are not enabled for this event")
+	}
+
+	registrations, _ := db.GetCollection("event_registrations")
+	err = registrations.FindOne(context.TODO(), bson.M{
+		"event_id":      event.ID,
+		"user_id":       userId,
+		"checked_in_at": bson.M{"$exists": true},
+	}).Err()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusForbidden).SendString("Only attendees who checked in get a certificate")
+		}
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error getting registration")
+	}
+
+	users, _ := db.GetCollection("users")
+	var user types.User
+	err = users.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&user)
+	if err != nil {
+		sentry.SentryHandler(err)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).SendString("User not found")
+		}
+		return c.Status(http.StatusInternalServerError).SendString("Error retrieving user: " + err.Error())
+	}
+
+	issued, err := issueCertificate(context.TODO(), *event, user)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error issuing certificate")
+	}
+
+	language := calendarLanguage(c)
+	if c.Query("language") == "" && user.Language != "" {
+		language = email.Language(user.Language)
+	}
+
+	pdf, err := certificate.Render(certificate.Certificate{
+		Name:      issued.Name,
+		Title:     issued.EventTitle.Localized(language),
+		Date:      issued.EventDate,
+		Organizer: issued.Organizer,
+		Code:      issued.Code,
+		VerifyURL: certificatesURL + issued.Code,
+	}, language)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error rendering certificate")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-certificate.pdf"`, event.Slug))
+
+	return c.Status(http.StatusOK).Send(pdf)
+}
+
+// @Summary Verify certificate
+// @Description Looks up a certificate of attendance by its verification code and returns who it was issued to for which event.
+// @Tags events
+// @Produce json
+// @Param code path string true "Verification code"
+// @Success 200 {object} types.Certificate
+// @Failure 404 {string} string "Certificate not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/certificates/{code} [get]
+func VerifyCertificate(c *fiber.Ctx) error {
+	code := strings.ToUpper(strings.TrimSpace(c.Params("code")))
+
+	collection, _ := db.GetCollection("certificates")
+	var issued types.Certificate
+	err := collection.FindOne(context.TODO(), bson.M{"code": code}).Decode(&issued)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).SendString("Certificate not found")
+		}
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding certificate")
+	}
+
+	return c.Status(http.StatusOK).JSON(issued)
+}
@@ -0,0 +1,319 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"henar-backend/db"
+	"henar-backend/internal/ical"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	defaultTicketSize = 256
+	maxTicketSize     = 1024
+)
+
+// checkInSecret signs tickets. Without it check-in is not available.
+func checkInSecret() string {
+	return os.Getenv("CHECKIN_SECRET")
+}
+
+func ticketSignature(registration types.EventRegistration, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("check-in:" + registration.ID.Hex() + ":" + registration.Event.Hex()))
+
+	return mac.Sum(nil)[:16]
+}
+
+// checkInToken is what the QR code of a registration holds: its ID and a
+// signature binding it to the event.
+func checkInToken(registration types.EventRegistration, secret string) string {
+	return registration.ID.Hex() + "." + base64.RawURLEncoding.EncodeToString(ticketSignature(registration, secret))
+}
+
+// verifyCheckInToken returns the registration ID of a token signed for the
+// event.
+func verifyCheckInToken(token string, eventId primitive.ObjectID, secret string) (primitive.ObjectID, bool) {
+	id, signature, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return primitive.NilObjectID, false
+	}
+	registrationId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	given, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+
+	expected := ticketSignature(types.EventRegistration{ID: registrationId, Event: eventId}, secret)
+	if !hmac.Equal(given, expected) {
+		return primitive.NilObjectID, false
+	}
+
+	return registrationId, true
+}
+
+// checkInEvent loads the event from the id parameter and checks that it
+// can be checked in to. When the event is nil the response has already
+// been written.
+func checkInEvent(c *fiber.Ctx) (*types.Event, string, error) {
+	secret := checkInSecret()
+	if secret == "" {
+		return nil, "", c.Status(http.StatusServiceUnavailable).SendString("Check-in is not configured")
+	}
+
+	event, err := findEvent(c)
+	if event == nil {
+		return nil, "", err
+	}
+
+	// attendance is tracked per registration, a series has one for all of
+	// its occurrences
+	if event.Recurrence != nil {
+		return nil, "", c.Status(http.StatusBadRequest).SendString("Recurring events don't support check-in, edit an occurrence to check in to it")
+	}
+
+	return event, secret, nil
+}
+
+// @Summary Get event ticket
+// @Description Returns the QR code of the current user's registration as a PNG image. The organizer scans it at the entrance to check the user in. Only users going to the event have a ticket.
+// @Tags events
+// @Produce png
+// @Param id path string true "Event ID"
+// @Param size query int false "Width and height in pixels (default 256, at most 1024)"
+// @Success 200 {file} file "QR code"
+// @Failure 400 {string} string "Invalid ID, size or recurring event"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "Event or ticket not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Failure 503 {string} string "Check-in is not configured"
+// @Router /v1/events/{id}/ticket [get]
+func GetTicket(c *fiber.Ctx) error {
+	userId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "not authorized"})
+	}
+
+	size := c.QueryInt("size", defaultTicketSize)
+	if size <= 0 || size > maxTicketSize {
+		return c.Status(http.StatusBadRequest).SendString("Invalid size")
+	}
+
+	event, secret, err := checkInEvent(c)
+	if event == nil {
+		return err
+	}
+
+	collection, _ := db.GetCollection("event_registrations")
+	var registration types.EventRegistration
+	err = collection.FindOne(context.TODO(), bson.M{"event_id": event.ID, "user_id": userId, "status": types.RSVPGoing}).Decode(&registration)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).SendString("Ticket not found")
+		}
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error getting registration")
+	}
+
+	png, err := qrcode.Encode(checkInToken(registration, secret), qrcode.Medium, size)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error generating QR code")
+	}
+
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+
+	return c.Status(http.StatusOK).Send(png)
+}
+
+// @Summary Check in to event
+// @Description Checks in the attendee whose ticket was scanned. Only the organizer and admins can check people in, each ticket is accepted once.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param body body types.CheckInRequestBody true "Token from the QR code"
+// @Success 200 {object} types.EventAttendee
+// @Failure 400 {string} string "Invalid ID, token or recurring event"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "Event or registration not found"
+// @Failure 409 {object} types.EventAttendee "Already checked in"
+// @Failure 500 {string} string "Internal Server Error"
+// @Failure 503 {string} string "Check-in is not configured"
+// @Router /v1/events/{id}/check-in [post]
+func CheckIn(c *fiber.Ctx) error {
+	var body types.CheckInRequestBody
+	if err := c.BodyParser(&body); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+	}
+	v := validator.New()
+	if err := v.Struct(body); err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Validation error: " + err.Error())
+	}
+
+	event, secret, err := checkInEvent(c)
+	if event == nil {
+		return err
+	}
+
+	if c.Locals("userRole") != "admin" &&
+		c.Locals("user_id") != event.CreatedBy.Hex() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	registrationId, ok := verifyCheckInToken(body.Token, event.ID, secret)
+	if !ok {
+		return c.Status(http.StatusBadRequest).SendString("Invalid ticket")
+	}
+	organizerId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "not authorized"})
+	}
+
+	// only the first scan of a ticket is recorded
+	collection, _ := db.GetCollection("event_registrations")
+	now := time.Now()
+	result, err := collection.UpdateOne(context.TODO(),
+		bson.M{
+			"_id":           registrationId,
+			"event_id":      event.ID,
+			"status":        types.RSVPGoing,
+			"checked_in_at": bson.M{"$exists": false},
+		},
+		bson.M{"$set": bson.M{"checked_in_at": now, "checked_in_by": organizerId}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error checking in")
+	}
+
+	attendees, err := findAttendees(context.TODO(), bson.M{"_id": registrationId, "event_id": event.ID})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding attendee")
+	}
+	if len(attendees) == 0 {
+		return c.Status(http.StatusNotFound).SendString("Registration not found")
+	}
+
+	attendee := attendees[0]
+	switch {
+	case result.ModifiedCount > 0:
+		return c.Status(http.StatusOK).JSON(attendee)
+	case attendee.CheckedInAt != nil:
+		return c.Status(http.StatusConflict).JSON(attendee)
+	}
+
+	return c.Status(http.StatusBadRequest).SendString("Registration is " + string(attendee.Status) + ", not going")
+}
+
+// @Summary Get event attendance
+// @Description Reports who of the people going to an event checked in, checked in first. Only the organizer and admins can see it. With format=csv the report is a CSV file.
+// @Tags events
+// @Produce json,text/csv
+// @Param id path string true "Event ID"
+// @Param format query string false "csv to download the report as a CSV file"
+// @Success 200 {object} types.AttendanceReport
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "Event not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/{id}/attendance [get]
+func GetAttendance(c *fiber.Ctx) error {
+	event, err := findEvent(c)
+	if event == nil {
+		return err
+	}
+
+	if c.Locals("userRole") != "admin" &&
+		c.Locals("user_id") != event.CreatedBy.Hex() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	attendees, err := findAttendees(context.TODO(), bson.M{"event_id": event.ID, "status": types.RSVPGoing})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding attendees")
+	}
+
+	report := types.AttendanceReport{Going: len(attendees), Attendees: []types.EventAttendee{}}
+	var absent []types.EventAttendee
+	for _, attendee := range attendees {
+		if attendee.CheckedInAt != nil {
+			report.CheckedIn++
+			report.Attendees = append(report.Attendees, attendee)
+		} else {
+			absent = append(absent, attendee)
+		}
+	}
+	report.Attendees = append(report.Attendees, absent...)
+
+	if c.Query("format") == "csv" {
+		return sendAttendanceCSV(c, *event, report)
+	}
+
+	return c.Status(http.StatusOK).JSON(report)
+}
+
+// csvCell keeps spreadsheets from running names as formulas.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+
+	return value
+}
+
+func sendAttendanceCSV(c *fiber.Ctx, event types.Event, report types.AttendanceReport) error {
+	var body bytes.Buffer
+	w := csv.NewWriter(&body)
+	w.Write([]string{"first_name", "last_name", "registered_at", "checked_in", "checked_in_at"})
+	for _, attendee := range report.Attendees {
+		checkedIn, checkedInAt := "no", ""
+		if attendee.CheckedInAt != nil {
+			checkedIn, checkedInAt = "yes", attendee.CheckedInAt.In(ical.Location).Format(time.RFC3339)
+		}
+		w.Write([]string{
+			csvCell(attendee.FirstName),
+			csvCell(attendee.LastName),
+			attendee.CreatedAt.In(ical.Location).Format(time.RFC3339),
+			checkedIn,
+			checkedInAt,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error writing report")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+event.Slug+`-attendance.csv"`)
+
+	return c.Status(http.StatusOK).Send(body.Bytes())
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"henar-backend/db"
 	"henar-backend/sentry"
+	"henar-backend/static"
 	"henar-backend/types"
 	"henar-backend/utils"
 	"net/http"
@@ -18,20 +19,25 @@ import (
 )
 
 // @Summary Get all events
-// @Description Retrieves all events
+// @Description Retrieves all events ordered by date. Within a date range recurring events are listed with each of their occurrences, for upcoming or past events with the nearest one.
 // @Tags events
 // @Accept json
 // @Produce json
 // @Success 200 {array} types.Event
+// @Failure 400 {string} string "Invalid pagination, range or time zone"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /v1/events [get]
 // @Param limit query int false "Limit"
 // @Param offset query int false "Offset"
-// @Param sort query string false "Comma-separated list of sort fields and directions, e.g. views,-applicants,tags"
+// @Param sort query string false "date (default) or -date, without a date filter also views, -applicants, tags"
 // @Param language query string false "Language code for the title (default 'en')"
 // @Param title query string false "Substring to match in the title"
 // @Param tags query string false "Comma-separated list of tag IDs to filter by"
 // @Param location query string false "Location ID to filter by"
+// @Param from query string false "Events at or after this time, RFC 3339 or YYYY-MM-DD"
+// @Param to query string false "Events before this time, at most 366 days after from"
+// @Param when query string false "upcoming, past (latest first) or today, instead of from and to"
+// @Param tz query string false "IANA time zone of dates without a time, of today and of the returned dates (default Asia/Yerevan)"
 func GetEvents(c *fiber.Ctx) error {
 	collection, _ := db.GetCollection("events")
 
@@ -49,23 +55,48 @@ func GetEvents(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).SendString(errMsg)
 	}
 
-	sort := utils.GetSort(c)
-	if len(sort) != 0 {
-		findOptions.SetSort(sort)
-	}
-
-	// Query the database and get the cursor
-	cursor, err := collection.Find(context.TODO(), filter, findOptions)
+	w, err := dateWindow(c)
 	if err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error finding events")
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid date filter: " + err.Error())
 	}
 
-	// Get the results from the cursor
 	var results []types.Event
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(http.StatusInternalServerError).SendString("Error finding projects")
+	if !w.isOpen() {
+		// occurrences of recurring events aren't stored, so they are merged
+		// into the page here
+		results, err = listEvents(context.TODO(), filter, w, *findOptions.Skip, *findOptions.Limit)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Error finding events")
+		}
+	} else {
+		direction := 1
+		if w.descending {
+			direction = -1
+		}
+		findOptions.SetSort(bson.D{{Key: "date", Value: direction}, {Key: "_id", Value: direction}})
+
+		sort := utils.GetSort(c)
+		if len(sort) != 0 {
+			findOptions.SetSort(sort)
+		}
+
+		// Query the database and get the cursor
+		cursor, err := collection.Find(context.TODO(), filter, findOptions)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Error finding events")
+		}
+
+		// Get the results from the cursor
+		if err = cursor.All(context.TODO(), &results); err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusInternalServerError).SendString("Error finding projects")
+		}
+	}
+
+	for i := range results {
+		results[i].Date = results[i].Date.In(w.loc)
 	}
 
 	if c.Locals("userRole") != "admin" {
@@ -87,11 +118,13 @@ func GetEvents(c *fiber.Ctx) error {
 }
 
 // @Summary Get event by slug
-// @Description Retrieves a event by its slug
+// @Description Retrieves a event by its slug, or with format=ics an .ics file to add it to a calendar
 // @Tags events
 // @Accept json
-// @Produce json
+// @Produce json,text/calendar
 // @Param slug path string true "Event slug"
+// @Param format query string false "ics to download the event as an iCalendar file"
+// @Param language query string false "Language of the .ics title and description (en, ru, hy)"
 // @Success 200 {object} types.Event
 // @Failure 400 {string} string "Invalid slug"
 // @Failure 404 {string} string "Event not found"
@@ -122,6 +155,10 @@ func GetEvent(c *fiber.Ctx) error {
 		utils.UpdateResultForUserRole(&result, fieldsToUpdate)
 	}
 
+	if c.Query("format") == "ics" {
+		return sendEventCalendar(c, result)
+	}
+
 	// Marshal the event struct to JSON format
 	jsonBytes, err := json.Marshal(result)
 	if err != nil {
@@ -164,6 +201,11 @@ func CreateEvent(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).SendString("Error retrieving created event: " + err.Error())
 	}
 
+	err = validateRecurrence(event)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid recurrence: " + err.Error())
+	}
+
 	// TODO: POST: pending by default, but Admin need to set any
 	// TODO: user can creare reason_of_reject, remove access
 	// TODO: need ability for user can change ModerationStatus
@@ -177,6 +219,9 @@ func CreateEvent(c *fiber.Ctx) error {
 	}
 
 	event.CreatedBy = userObjId
+	event.GoingCount = 0
+	event.SeriesID = nil
+	event.OriginalDate = nil
 	slugText := utils.CreateSlug(event.Title)
 	event.Slug = slugText
 
@@ -223,6 +268,17 @@ func CreateEvent(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).SendString("Error updating user: " + err.Error())
 	}
 
+	coverRef := types.FileReference{Collection: "events", Document: createdEvent.ID, Field: "cover"}
+	err = static.SetReferences(coverRef, []string{createdEvent.Cover})
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	err = scheduleReminders(context.TODO(), createdEvent)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// Set the response headers and write the response body
 	return c.Status(http.StatusCreated).JSON(createdEvent)
 }
@@ -265,6 +321,11 @@ func UpdateEvent(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).SendString("Validation error: " + err.Error())
 	}
 
+	err = validateRecurrence(updateBody)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid recurrence: " + err.Error())
+	}
+
 	// Find the event document from MongoDB
 	var result types.Event
 	err = collection.FindOne(context.TODO(), bson.M{"_id": objId}).Decode(&result)
@@ -295,6 +356,15 @@ func UpdateEvent(c *fiber.Ctx) error {
 
 	slugText := utils.CreateSlug(updateBody.Title)
 	updateBody.Slug = slugText
+	// seats are counted by registrations only
+	updateBody.GoingCount = 0
+	// editing the whole series keeps its cancelled occurrences, single
+	// occurrences are edited through their own endpoint
+	if updateBody.Recurrence != nil && updateBody.Recurrence.ExDates == nil && result.Recurrence != nil {
+		updateBody.Recurrence.ExDates = result.Recurrence.ExDates
+	}
+	updateBody.SeriesID = nil
+	updateBody.OriginalDate = nil
 
 	// Update the event document in MongoDB
 	filter := bson.M{"_id": objId}
@@ -314,6 +384,24 @@ func UpdateEvent(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).SendString("Error retrieving updated event: " + err.Error())
 	}
 
+	coverRef := types.FileReference{Collection: "events", Document: objId, Field: "cover"}
+	err = static.SetReferences(coverRef, []string{updatedEvent.Cover})
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	// a raised capacity frees seats for the waitlist
+	err = promoteWaitlist(context.TODO(), updatedEvent)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	// reminders follow the event when it moves
+	err = scheduleReminders(context.TODO(), updatedEvent)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// Set the response headers and write the response body
 	return c.Status(http.StatusOK).JSON(updatedEvent)
 }
@@ -374,6 +462,26 @@ func DeleteEvent(c *fiber.Ctx) error {
 		return c.Status(http.StatusNotFound).SendString("Event not found")
 	}
 
+	err = static.ReleaseReferences("events", eventObjId)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	err = cancelEvent(context.TODO(), event)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	err = deleteRegistrations(context.TODO(), eventObjId)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	err = deleteOccurrences(context.TODO(), eventObjId)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// update user
 	usersCollection, _ := db.GetCollection("users")
 	userObjId, err := primitive.ObjectIDFromHex(userId)
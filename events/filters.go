@@ -0,0 +1,237 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/ical"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxExpansionRange bounds the date range recurring events are expanded in.
+const maxExpansionRange = 366 * 24 * time.Hour
+
+// window is the date range events are listed in. A zero from or to leaves
+// that side open. Dates are listed in loc.
+type window struct {
+	from       time.Time
+	to         time.Time
+	descending bool
+	loc        *time.Location
+}
+
+func (w window) isOpen() bool {
+	return w.from.IsZero() && w.to.IsZero()
+}
+
+func (w window) isBounded() bool {
+	return !w.from.IsZero() && !w.to.IsZero()
+}
+
+func (w window) contains(t time.Time) bool {
+	return (w.from.IsZero() || !t.Before(w.from)) && (w.to.IsZero() || t.Before(w.to))
+}
+
+// dateWindow reads the from, to, when, tz and sort query parameters. Dates
+// without a time and "today" are taken in tz, Yerevan by default.
+func dateWindow(c *fiber.Ctx) (window, error) {
+	loc := ical.Location
+	if tz := c.Query("tz"); tz != "" {
+		var err error
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			return window{}, fmt.Errorf("unknown time zone %q", tz)
+		}
+	}
+
+	w := window{loc: loc}
+	now := time.Now()
+	when := c.Query("when")
+	if when != "" && (c.Query("from") != "" || c.Query("to") != "") {
+		return window{}, fmt.Errorf("when can't be combined with from or to")
+	}
+
+	switch when {
+	case "":
+		var err error
+		if from := c.Query("from"); from != "" {
+			if w.from, err = parseTime(from, loc); err != nil {
+				return window{}, err
+			}
+		}
+		if to := c.Query("to"); to != "" {
+			if w.to, err = parseTime(to, loc); err != nil {
+				return window{}, err
+			}
+		}
+	case "upcoming":
+		w.from = now
+	case "past":
+		w.to = now
+		w.descending = true
+	case "today":
+		year, month, day := now.In(loc).Date()
+		w.from = time.Date(year, month, day, 0, 0, 0, 0, loc)
+		w.to = w.from.AddDate(0, 0, 1)
+	default:
+		return window{}, fmt.Errorf("when must be upcoming, past or today")
+	}
+
+	switch c.Query("sort") {
+	case "date":
+		w.descending = false
+	case "-date":
+		w.descending = true
+	}
+
+	if w.isBounded() {
+		if !w.to.After(w.from) {
+			return window{}, fmt.Errorf("to must be after from")
+		}
+		if w.to.Sub(w.from) > maxExpansionRange {
+			return window{}, fmt.Errorf("the range can span at most 366 days")
+		}
+	}
+
+	return w, nil
+}
+
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q, expected RFC 3339 or YYYY-MM-DD", value)
+}
+
+// listEvents finds the events matching filter in the window, ordered by
+// date. Recurring events are listed with every occurrence in a bounded
+// window and with their nearest occurrence in an open one.
+func listEvents(ctx context.Context, filter bson.M, w window, skip, limit int64) ([]types.Event, error) {
+	collection, _ := db.GetCollection("events")
+
+	direction := 1
+	if w.descending {
+		direction = -1
+	}
+
+	dates := bson.M{}
+	if !w.from.IsZero() {
+		dates["$gte"] = w.from
+	}
+	if !w.to.IsZero() {
+		dates["$lt"] = w.to
+	}
+
+	single := bson.M{}
+	for key, value := range filter {
+		single[key] = value
+	}
+	single["recurrence"] = bson.M{"$exists": false}
+	if len(dates) > 0 {
+		single["date"] = dates
+	}
+
+	// only the first skip+limit single events can make it into the page
+	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: direction}, {Key: "_id", Value: direction}})
+	if limit > 0 {
+		findOptions.SetLimit(skip + limit)
+	}
+
+	cursor, err := collection.Find(ctx, single, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find events: %w", err)
+	}
+	events := []types.Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode events: %w", err)
+	}
+
+	series := bson.M{}
+	for key, value := range filter {
+		series[key] = value
+	}
+	series["recurrence"] = bson.M{"$exists": true}
+	if !w.to.IsZero() {
+		series["date"] = bson.M{"$lt": w.to}
+	}
+
+	cursor, err = collection.Find(ctx, series)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find recurring events: %w", err)
+	}
+	var masters []types.Event
+	if err := cursor.All(ctx, &masters); err != nil {
+		return nil, fmt.Errorf("failed to decode recurring events: %w", err)
+	}
+
+	if len(masters) > 0 {
+		edited, err := editedOccurrences(ctx, masters)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, master := range masters {
+			r, err := rule(master)
+			if err != nil {
+				sentry.SentryHandler(fmt.Errorf("invalid recurrence of event %s: %w", master.ID.Hex(), err))
+				continue
+			}
+
+			var occurrences []time.Time
+			r.Each(master.Date.In(ical.Location), func(occurrence time.Time) bool {
+				if !w.to.IsZero() && !occurrence.Before(w.to) {
+					return false
+				}
+				if !w.contains(occurrence) || isException(master, occurrence) || edited[occurrenceKey(master.ID, occurrence)] {
+					return true
+				}
+
+				switch {
+				case w.isBounded():
+					occurrences = append(occurrences, occurrence)
+				case w.to.IsZero():
+					// without an end the nearest occurrence stands for the
+					// series in either sort direction
+					occurrences = append(occurrences, occurrence)
+					return false
+				default:
+					// only an end, the latest one before it wins
+					occurrences = []time.Time{occurrence}
+				}
+				return true
+			})
+
+			for _, occurrence := range occurrences {
+				events = append(events, occurrenceOf(master, occurrence))
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if w.descending {
+			return events[i].Date.After(events[j].Date)
+		}
+		return events[i].Date.Before(events[j].Date)
+	})
+
+	if skip >= int64(len(events)) {
+		return []types.Event{}, nil
+	}
+	events = events[skip:]
+	if limit > 0 && limit < int64(len(events)) {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
@@ -0,0 +1,303 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/ical"
+	"henar-backend/internal/recurrence"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// rule parses the recurrence of a series. Occurrences keep the wall clock
+// time of the first one in Yerevan.
+func rule(event types.Event) (recurrence.Rule, error) {
+	return recurrence.Parse(event.Recurrence.RRule, ical.Location)
+}
+
+func validateRecurrence(event types.Event) error {
+	if event.Recurrence == nil {
+		return nil
+	}
+
+	_, err := rule(event)
+	return err
+}
+
+func isException(event types.Event, occurrence time.Time) bool {
+	for _, exception := range event.Recurrence.ExDates {
+		if exception.Equal(occurrence) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasEnded reports whether the event, or the last occurrence of a series,
+// is in the past.
+func hasEnded(event types.Event) bool {
+	if event.Recurrence == nil {
+		return event.Date.Before(time.Now())
+	}
+
+	r, err := rule(event)
+	if err != nil {
+		return false
+	}
+	last, ends := r.Last(event.Date.In(ical.Location))
+
+	return ends && last.Before(time.Now())
+}
+
+func occurrenceKey(seriesId primitive.ObjectID, date time.Time) string {
+	return seriesId.Hex() + "/" + date.UTC().Format(time.RFC3339)
+}
+
+// editedOccurrences returns the occurrences of the series that are stored
+// on their own, wherever they were moved to.
+func editedOccurrences(ctx context.Context, masters []types.Event) (map[string]bool, error) {
+	ids := make([]primitive.ObjectID, 0, len(masters))
+	for _, master := range masters {
+		ids = append(ids, master.ID)
+	}
+
+	collection, _ := db.GetCollection("events")
+	cursor, err := collection.Find(ctx, bson.M{"series_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"series_id": 1, "original_date": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find edited occurrences: %w", err)
+	}
+
+	var overrides []types.Event
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode edited occurrences: %w", err)
+	}
+
+	edited := map[string]bool{}
+	for _, override := range overrides {
+		if override.SeriesID != nil && override.OriginalDate != nil {
+			edited[occurrenceKey(*override.SeriesID, *override.OriginalDate)] = true
+		}
+	}
+
+	return edited, nil
+}
+
+func occurrenceOf(master types.Event, date time.Time) types.Event {
+	occurrence := master
+	occurrence.Date = date
+	occurrence.SeriesID = &master.ID
+	occurrence.OriginalDate = &date
+
+	return occurrence
+}
+
+// findOccurrence loads the series from the id parameter and checks that the
+// date parameter is one of its occurrences. When the event is nil the
+// response has already been written.
+func findOccurrence(c *fiber.Ctx) (*types.Event, time.Time, error) {
+	event, err := findEvent(c)
+	if event == nil {
+		return nil, time.Time{}, err
+	}
+
+	if c.Locals("userRole") != "admin" &&
+		c.Locals("user_id") != event.CreatedBy.Hex() {
+		return nil, time.Time{}, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	if event.Recurrence == nil {
+		return nil, time.Time{}, c.Status(http.StatusBadRequest).SendString("Event is not recurring")
+	}
+
+	param, _ := url.PathUnescape(c.Params("date"))
+	date, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return nil, time.Time{}, c.Status(http.StatusBadRequest).SendString("Invalid date, expected RFC 3339")
+	}
+
+	r, err := rule(*event)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return nil, time.Time{}, c.Status(http.StatusInternalServerError).SendString("Invalid recurrence: " + err.Error())
+	}
+	if !r.Includes(event.Date.In(ical.Location), date) || isException(*event, date) {
+		return nil, time.Time{}, c.Status(http.StatusNotFound).SendString("Occurrence not found")
+	}
+
+	return event, date.UTC(), nil
+}
+
+// @Summary Update event occurrence
+// @Description Edits a single occurrence of a recurring event, the rest of the series stays as it is. The edited occurrence becomes an event of its own that keeps its link to the series.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path string true "Series event ID"
+// @Param date path string true "Original date of the occurrence, RFC 3339"
+// @Param event body types.Event true "Event Object"
+// @Success 200 {object} types.Event
+// @Failure 400 {string} string "Invalid ID, date or Bad Request"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "Event or occurrence not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/{id}/occurrences/{date} [patch]
+func UpdateOccurrence(c *fiber.Ctx) error {
+	var updateBody types.Event
+	err := c.BodyParser(&updateBody)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+	}
+
+	v := validator.New()
+	err = v.Struct(updateBody)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Validation error: " + err.Error())
+	}
+
+	series, date, err := findOccurrence(c)
+	if series == nil {
+		return err
+	}
+
+	// an occurrence doesn't repeat and keeps the series' identity
+	updateBody.ID = primitive.NilObjectID
+	updateBody.Recurrence = nil
+	updateBody.GoingCount = 0
+	updateBody.CreatedBy = series.CreatedBy
+	updateBody.SeriesID = &series.ID
+	updateBody.OriginalDate = &date
+	updateBody.Slug = series.Slug + "-" + date.In(ical.Location).Format("2006-01-02")
+
+	collection, _ := db.GetCollection("events")
+	var occurrence types.Event
+	err = collection.FindOneAndUpdate(context.TODO(),
+		bson.M{"series_id": series.ID, "original_date": date},
+		bson.M{"$set": updateBody},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&occurrence)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error updating occurrence: " + err.Error())
+	}
+
+	// the edited occurrence is reminded of on its own
+	for _, event := range []types.Event{occurrence, *series} {
+		if err := scheduleReminders(context.TODO(), event); err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(occurrence)
+}
+
+// @Summary Cancel event occurrence
+// @Description Cancels a single occurrence of a recurring event, the rest of the series stays as it is. Delete the event itself to cancel the whole series.
+// @Tags events
+// @Produce json
+// @Param id path string true "Series event ID"
+// @Param date path string true "Original date of the occurrence, RFC 3339"
+// @Success 200 {string} string "Occurrence cancelled"
+// @Failure 400 {string} string "Invalid ID or date"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "Event or occurrence not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/{id}/occurrences/{date} [delete]
+func CancelOccurrence(c *fiber.Ctx) error {
+	series, date, err := findOccurrence(c)
+	if series == nil {
+		return err
+	}
+
+	collection, _ := db.GetCollection("events")
+	_, err = collection.UpdateOne(context.TODO(),
+		bson.M{"_id": series.ID},
+		bson.M{"$addToSet": bson.M{"recurrence.exdates": date}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error cancelling occurrence: " + err.Error())
+	}
+
+	series.Recurrence.ExDates = append(series.Recurrence.ExDates, date)
+	if err := scheduleReminders(context.TODO(), *series); err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	// an edited occurrence goes away with its registrations
+	eventIds := []primitive.ObjectID{series.ID}
+	var occurrence types.Event
+	err = collection.FindOneAndDelete(context.TODO(), bson.M{"series_id": series.ID, "original_date": date}).Decode(&occurrence)
+	if err != nil && err != mongo.ErrNoDocuments {
+		sentry.SentryHandler(err)
+	}
+	found := err == nil
+	if found {
+		eventIds = append(eventIds, occurrence.ID)
+		if err := cancelReminders(context.TODO(), occurrence.ID); err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+
+	if date.After(time.Now()) {
+		err = notifyRegistrants(context.TODO(), eventIds, types.EventCancelled, series.Title, types.NotificationBody{
+			EventID:   series.Slug,
+			EventDate: &date,
+		}, types.RSVPGoing, types.RSVPWaitlisted)
+		if err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+
+	if found {
+		if err := deleteRegistrations(context.TODO(), occurrence.ID); err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Occurrence cancelled"})
+}
+
+// deleteOccurrences removes the edited occurrences of a deleted series.
+func deleteOccurrences(ctx context.Context, seriesId primitive.ObjectID) error {
+	collection, _ := db.GetCollection("events")
+
+	cursor, err := collection.Find(ctx, bson.M{"series_id": seriesId}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find occurrences of %s: %w", seriesId.Hex(), err)
+	}
+	var occurrences []types.Event
+	if err := cursor.All(ctx, &occurrences); err != nil {
+		return fmt.Errorf("failed to decode occurrences of %s: %w", seriesId.Hex(), err)
+	}
+
+	for _, occurrence := range occurrences {
+		if err := deleteRegistrations(ctx, occurrence.ID); err != nil {
+			return err
+		}
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.M{"series_id": seriesId})
+	if err != nil {
+		return fmt.Errorf("failed to delete occurrences of %s: %w", seriesId.Hex(), err)
+	}
+
+	return nil
+}
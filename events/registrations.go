@@ -0,0 +1,425 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/notifications"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// reserveSeat takes one of the event's seats. It reports false when the
+// event is full.
+func reserveSeat(ctx context.Context, eventId primitive.ObjectID) (bool, error) {
+	collection, _ := db.GetCollection("events")
+
+	filter := bson.M{
+		"_id": eventId,
+		"$expr": bson.M{"$or": bson.A{
+			bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$capacity", 0}}, 0}},
+			bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$going_count", 0}}, "$capacity"}},
+		}},
+	}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"going_count": 1}})
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve a seat: %w", err)
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+func releaseSeat(ctx context.Context, eventId primitive.ObjectID) error {
+	collection, _ := db.GetCollection("events")
+
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": eventId, "going_count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"going_count": -1}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release a seat: %w", err)
+	}
+
+	return nil
+}
+
+// promoteWaitlist gives free seats to waitlisted users, first come first
+// served, and lets them know.
+func promoteWaitlist(ctx context.Context, event types.Event) error {
+	collection, _ := db.GetCollection("event_registrations")
+
+	for {
+		var next types.EventRegistration
+		err := collection.FindOne(ctx,
+			bson.M{"event_id": event.ID, "status": types.RSVPWaitlisted},
+			options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: 1}}),
+		).Decode(&next)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find waitlisted registration: %w", err)
+		}
+
+		reserved, err := reserveSeat(ctx, event.ID)
+		if err != nil || !reserved {
+			return err
+		}
+
+		now := time.Now()
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": next.ID, "status": types.RSVPWaitlisted},
+			bson.M{"$set": bson.M{"status": types.RSVPGoing, "updated_at": now, "promoted_at": now}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			// the user cancelled meanwhile, the seat goes to the next one
+			if releaseErr := releaseSeat(ctx, event.ID); releaseErr != nil {
+				return releaseErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to promote registration: %w", err)
+			}
+			continue
+		}
+
+		err = notifications.CreateNotification(types.EventWaitlistPromoted, next.User, types.NotificationBody{
+			EventID:    event.Slug,
+			EventTitle: event.Title.En,
+		})
+		if err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+}
+
+// cancelRegistration removes the user's registration and hands a freed seat
+// to the waitlist.
+func cancelRegistration(ctx context.Context, event types.Event, registration types.EventRegistration) error {
+	if registration.Status != types.RSVPGoing {
+		return nil
+	}
+
+	if err := releaseSeat(ctx, event.ID); err != nil {
+		return err
+	}
+
+	return promoteWaitlist(ctx, event)
+}
+
+func findEvent(c *fiber.Ctx) (*types.Event, error) {
+	eventId, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	collection, _ := db.GetCollection("events")
+	var event types.Event
+	err = collection.FindOne(context.TODO(), bson.M{"_id": eventId}).Decode(&event)
+	if err != nil {
+		sentry.SentryHandler(err)
+		if err == mongo.ErrNoDocuments {
+			return nil, c.Status(fiber.StatusNotFound).SendString("Event not found")
+		}
+		return nil, c.Status(fiber.StatusInternalServerError).SendString("Error getting event: " + err.Error())
+	}
+
+	return &event, nil
+}
+
+// @Summary RSVP to event
+// @Description Registers the current user as going to or interested in an event. When the event is full a going registration is waitlisted and promoted as soon as a seat frees up.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param body body types.RSVPRequestBody true "going or interested"
+// @Success 200 {object} types.EventRegistration
+// @Failure 400 {string} string "Invalid ID, status or past event"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "Event not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/{id}/rsvp [post]
+func RSVP(c *fiber.Ctx) error {
+	userId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "not authorized"})
+	}
+
+	var body types.RSVPRequestBody
+	if err := c.BodyParser(&body); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+	}
+	v := validator.New()
+	if err := v.Struct(body); err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid status: " + string(body.Status))
+	}
+
+	event, err := findEvent(c)
+	if event == nil {
+		return err
+	}
+	if hasEnded(*event) {
+		return c.Status(http.StatusBadRequest).SendString("Event is over")
+	}
+
+	collection, _ := db.GetCollection("event_registrations")
+	filter := bson.M{"event_id": event.ID, "user_id": userId}
+
+	var existing types.EventRegistration
+	err = collection.FindOne(context.TODO(), filter).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error getting registration")
+	}
+	found := err == nil
+
+	// going again keeps the seat or the place in the waitlist
+	if found && body.Status == types.RSVPGoing && existing.Status != types.RSVPInterested {
+		return c.Status(http.StatusOK).JSON(existing)
+	}
+
+	status := body.Status
+	if status == types.RSVPGoing {
+		reserved, err := reserveSeat(context.TODO(), event.ID)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusInternalServerError).SendString("Error reserving a seat")
+		}
+		if !reserved {
+			status = types.RSVPWaitlisted
+		}
+	}
+
+	// the status condition together with the unique index keeps concurrent
+	// requests from taking two seats
+	now := time.Now()
+	upsertFilter := bson.M{"event_id": event.ID, "user_id": userId, "status": bson.M{"$exists": false}}
+	if found {
+		upsertFilter["status"] = existing.Status
+	}
+	var registration types.EventRegistration
+	err = collection.FindOneAndUpdate(context.TODO(), upsertFilter,
+		bson.M{
+			"$set":         bson.M{"status": status, "updated_at": now},
+			"$unset":       bson.M{"promoted_at": ""},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&registration)
+	if err != nil {
+		if status == types.RSVPGoing {
+			if releaseErr := releaseSeat(context.TODO(), event.ID); releaseErr != nil {
+				sentry.SentryHandler(releaseErr)
+			}
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(http.StatusConflict).SendString("Registration changed meanwhile, try again")
+		}
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error saving registration")
+	}
+
+	if found && existing.Status == types.RSVPGoing {
+		if err := cancelRegistration(context.TODO(), *event, existing); err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(registration)
+}
+
+// @Summary Cancel RSVP
+// @Description Removes the current user's registration for an event. A freed seat goes to the first user on the waitlist.
+// @Tags events
+// @Produce json
+// @Param id path string true "Event ID"
+// @Success 200 {string} string "Registration cancelled"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "Event or registration not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/{id}/rsvp [delete]
+func CancelRSVP(c *fiber.Ctx) error {
+	userId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "not authorized"})
+	}
+
+	event, err := findEvent(c)
+	if event == nil {
+		return err
+	}
+
+	collection, _ := db.GetCollection("event_registrations")
+	var registration types.EventRegistration
+	err = collection.FindOneAndDelete(context.TODO(), bson.M{"event_id": event.ID, "user_id": userId}).Decode(&registration)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).SendString("Registration not found")
+		}
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error cancelling registration")
+	}
+
+	if err := cancelRegistration(context.TODO(), *event, registration); err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Registration cancelled"})
+}
+
+// @Summary Get event attendees
+// @Description Lists the registrations for an event, going first, then waitlisted in waitlist order, then interested. Only the organizer and admins can see them.
+// @Tags events
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param status query string false "Filter by status: going, waitlisted or interested"
+// @Success 200 {array} types.EventAttendee
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "Event not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/events/{id}/attendees [get]
+func GetAttendees(c *fiber.Ctx) error {
+	event, err := findEvent(c)
+	if event == nil {
+		return err
+	}
+
+	if c.Locals("userRole") != "admin" &&
+		c.Locals("user_id") != event.CreatedBy.Hex() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	match := bson.M{"event_id": event.ID}
+	if status := c.Query("status"); status != "" {
+		match["status"] = status
+	}
+
+	attendees, err := findAttendees(context.TODO(), match)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding attendees")
+	}
+
+	return c.Status(http.StatusOK).JSON(attendees)
+}
+
+// findAttendees lists the matching registrations with the names of the
+// users, going first, then waitlisted in waitlist order, then interested.
+func findAttendees(ctx context.Context, match bson.M) ([]types.EventAttendee, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{"order": bson.M{"$indexOfArray": bson.A{
+			bson.A{types.RSVPGoing, types.RSVPWaitlisted, types.RSVPInterested}, "$status",
+		}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "order", Value: 1}, {Key: "updated_at", Value: 1}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$unwind", Value: "$user"}},
+		{{Key: "$addFields", Value: bson.M{
+			"first_name": "$user.user_body.first_name",
+			"last_name":  "$user.user_body.last_name",
+			"avatar":     "$user.user_body.avatar",
+		}}},
+		{{Key: "$project", Value: bson.M{"user": 0, "order": 0}}},
+	}
+
+	collection, _ := db.GetCollection("event_registrations")
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find attendees: %w", err)
+	}
+
+	attendees := []types.EventAttendee{}
+	if err := cursor.All(ctx, &attendees); err != nil {
+		return nil, fmt.Errorf("failed to decode attendees: %w", err)
+	}
+
+	return attendees, nil
+}
+
+// @Summary Get my upcoming events
+// @Description Lists the upcoming events the current user registered for, soonest first, each with the user's registration.
+// @Tags events
+// @Produce json
+// @Param status query string false "Filter by status: going, waitlisted or interested"
+// @Success 200 {array} types.UserEvent
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/my-events [get]
+func GetMyEvents(c *fiber.Ctx) error {
+	userId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "not authorized"})
+	}
+
+	match := bson.M{"user_id": userId}
+	if status := c.Query("status"); status != "" {
+		match["status"] = status
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "events",
+			"localField":   "event_id",
+			"foreignField": "_id",
+			"as":           "event",
+		}}},
+		{{Key: "$unwind", Value: "$event"}},
+		// series stay listed while they run
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"event.date": bson.M{"$gte": time.Now()}},
+			bson.M{"event.recurrence": bson.M{"$exists": true}},
+		}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "event.date", Value: 1}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": bson.M{
+			"$mergeObjects": bson.A{"$event", bson.M{"registration": "$$ROOT"}},
+		}}}},
+		{{Key: "$project", Value: bson.M{"registration.event": 0}}},
+	}
+
+	collection, _ := db.GetCollection("event_registrations")
+	cursor, err := collection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding events")
+	}
+
+	events := []types.UserEvent{}
+	if err := cursor.All(context.TODO(), &events); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error querying database: " + err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(events)
+}
+
+// deleteRegistrations removes every registration for a deleted event.
+func deleteRegistrations(ctx context.Context, eventId primitive.ObjectID) error {
+	collection, _ := db.GetCollection("event_registrations")
+
+	_, err := collection.DeleteMany(ctx, bson.M{"event_id": eventId})
+	if err != nil {
+		return fmt.Errorf("failed to delete registrations of %s: %w", eventId.Hex(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/email"
+	"henar-backend/internal/ical"
+	"henar-backend/internal/scheduler"
+	"henar-backend/notifications"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const reminderJob = "event-reminder"
+
+// defaultReminderOffsets are how long before an event its registrants are
+// reminded of it.
+var defaultReminderOffsets = []time.Duration{24 * time.Hour, time.Hour}
+
+// jobs schedules the reminders. Without it events are not reminded of.
+var (
+	jobs            *scheduler.Queue
+	reminderOffsets = defaultReminderOffsets
+)
+
+// reminder is the payload of a reminder job: the occurrence it reminds of
+// and how long before it the job runs.
+type reminder struct {
+	EventID primitive.ObjectID `bson:"event_id"`
+	Date    time.Time          `bson:"date"`
+	Offset  time.Duration      `bson:"offset"`
+}
+
+// InitReminders connects events to the job queue. The offsets are read
+// from EVENT_REMINDER_OFFSETS as comma-separated durations, e.g. "24h,1h".
+func InitReminders(queue *scheduler.Queue) error {
+	offsets := defaultReminderOffsets
+	if value := os.Getenv("EVENT_REMINDER_OFFSETS"); value != "" {
+		offsets = nil
+		for _, part := range strings.Split(value, ",") {
+			offset, err := time.ParseDuration(strings.TrimSpace(part))
+			if err != nil || offset <= 0 {
+				return fmt.Errorf("invalid EVENT_REMINDER_OFFSETS %q", value)
+			}
+			offsets = append(offsets, offset)
+		}
+	}
+
+	reminderOffsets = offsets
+	jobs = queue
+	jobs.Handle(reminderJob, sendReminder)
+
+	return nil
+}
+
+func remindersKey(eventId primitive.ObjectID) string {
+	return reminderJob + "/" + eventId.Hex() + "/"
+}
+
+func reminderKey(eventId primitive.ObjectID, offset time.Duration) string {
+	return remindersKey(eventId) + offset.String()
+}
+
+// nextOccurrence is the first occurrence of the event after the given
+// time. Edited occurrences of a series are events of their own and are
+// skipped.
+func nextOccurrence(event types.Event, after time.Time, edited map[string]bool) (time.Time, bool) {
+	if event.Recurrence == nil {
+		return event.Date, event.Date.After(after)
+	}
+
+	r, err := rule(event)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	found := false
+	r.Each(event.Date.In(ical.Location), func(occurrence time.Time) bool {
+		if !occurrence.After(after) || isException(event, occurrence) || edited[occurrenceKey(event.ID, occurrence)] {
+			return true
+		}
+		next, found = occurrence, true
+		return false
+	})
+
+	return next, found
+}
+
+// scheduleReminder moves the reminder of the event at offset to the first
+// occurrence after the given time that is far enough away.
+func scheduleReminder(ctx context.Context, event types.Event, offset time.Duration, after time.Time, edited map[string]bool) error {
+	key := reminderKey(event.ID, offset)
+
+	if soon := time.Now().Add(offset); after.Before(soon) {
+		after = soon
+	}
+	date, ok := nextOccurrence(event, after, edited)
+	if !ok {
+		return jobs.Cancel(ctx, key)
+	}
+
+	return jobs.Schedule(ctx, key, reminderJob, date.Add(-offset), reminder{
+		EventID: event.ID,
+		Date:    date.UTC(),
+		Offset:  offset,
+	})
+}
+
+// scheduleReminders (re)schedules every reminder of the event, e.g. after
+// it was created or its date changed.
+func scheduleReminders(ctx context.Context, event types.Event) error {
+	if jobs == nil {
+		return nil
+	}
+
+	edited := map[string]bool{}
+	if event.Recurrence != nil {
+		var err error
+		edited, err = editedOccurrences(ctx, []types.Event{event})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, offset := range reminderOffsets {
+		if err := scheduleReminder(ctx, event, offset, time.Now(), edited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cancelReminders drops the reminders of a deleted event.
+func cancelReminders(ctx context.Context, eventId primitive.ObjectID) error {
+	if jobs == nil {
+		return nil
+	}
+
+	return jobs.Cancel(ctx, remindersKey(eventId))
+}
+
+// sendReminder reminds the users going to an event of it and, for a
+// series, schedules the reminder of the next occurrence.
+func sendReminder(ctx context.Context, job types.ScheduledJob) error {
+	var r reminder
+	if err := bson.Unmarshal(job.Payload, &r); err != nil {
+		return fmt.Errorf("failed to decode reminder %s: %w", job.Key, err)
+	}
+
+	collection, _ := db.GetCollection("events")
+	var event types.Event
+	err := collection.FindOne(ctx, bson.M{"_id": r.EventID}).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find event %s: %w", r.EventID.Hex(), err)
+	}
+
+	edited := map[string]bool{}
+	if event.Recurrence != nil {
+		edited, err = editedOccurrences(ctx, []types.Event{event})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the event may have moved since, then the reminder was rescheduled
+	// with it; one that is late past the start isn't sent at all
+	date, ok := nextOccurrence(event, r.Date.Add(-time.Second), edited)
+	if ok && date.Equal(r.Date) && date.After(time.Now()) {
+		// people going to the whole series go to its edited occurrences too
+		eventIds := []primitive.ObjectID{event.ID}
+		if event.SeriesID != nil {
+			eventIds = append(eventIds, *event.SeriesID)
+		}
+
+		err = notifyRegistrants(ctx, eventIds, types.EventReminder, event.Title, types.NotificationBody{
+			EventID:   event.Slug,
+			EventDate: &date,
+		}, types.RSVPGoing)
+		if err != nil {
+			return err
+		}
+	}
+
+	if event.Recurrence != nil {
+		return scheduleReminder(ctx, event, r.Offset, r.Date, edited)
+	}
+
+	return nil
+}
+
+// notifyRegistrants sends a notification to every user registered for any
+// of the events with one of the statuses, once per user. The event title is
+// given in the user's language.
+func notifyRegistrants(ctx context.Context, eventIds []primitive.ObjectID, notificationType types.NotificationType, title types.Translations, body types.NotificationBody, statuses ...types.RSVPStatus) error {
+	collection, _ := db.GetCollection("event_registrations")
+
+	userIds, err := collection.Distinct(ctx, "user_id", bson.M{
+		"event_id": bson.M{"$in": eventIds},
+		"status":   bson.M{"$in": statuses},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find registrants: %w", err)
+	}
+
+	users, _ := db.GetCollection("users")
+	cursor, err := users.Find(ctx, bson.M{"_id": bson.M{"$in": userIds}},
+		options.Find().SetProjection(bson.M{"user_body.language": 1}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find registrants: %w", err)
+	}
+	var registrants []types.User
+	if err := cursor.All(ctx, &registrants); err != nil {
+		return fmt.Errorf("failed to decode registrants: %w", err)
+	}
+
+	for _, user := range registrants {
+		body.EventTitle = title.Localized(email.Language(user.Language))
+		if err := notifications.CreateNotification(notificationType, user.ID, body); err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+
+	return nil
+}
+
+// cancelEvent lets the users going to or waiting for a deleted event, or
+// any of the edited occurrences of a deleted series, know it won't happen
+// and drops its reminders. It runs before the registrations are deleted.
+func cancelEvent(ctx context.Context, event types.Event) error {
+	eventIds := []primitive.ObjectID{event.ID}
+	if event.Recurrence != nil {
+		collection, _ := db.GetCollection("events")
+		cursor, err := collection.Find(ctx, bson.M{"series_id": event.ID}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return fmt.Errorf("failed to find occurrences of %s: %w", event.ID.Hex(), err)
+		}
+		var occurrences []types.Event
+		if err := cursor.All(ctx, &occurrences); err != nil {
+			return fmt.Errorf("failed to decode occurrences of %s: %w", event.ID.Hex(), err)
+		}
+		for _, occurrence := range occurrences {
+			eventIds = append(eventIds, occurrence.ID)
+		}
+	}
+
+	for _, eventId := range eventIds {
+		if err := cancelReminders(ctx, eventId); err != nil {
+			return err
+		}
+	}
+
+	if hasEnded(event) {
+		return nil
+	}
+
+	body := types.NotificationBody{EventID: event.Slug}
+	if event.Recurrence == nil {
+		body.EventDate = &event.Date
+	}
+
+	return notifyRegistrants(ctx, eventIds, types.EventCancelled, event.Title, body, types.RSVPGoing, types.RSVPWaitlisted)
+}
@@ -0,0 +1,161 @@
+// Package certificate renders certificates of attendance as PDF files.
+package certificate
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"time"
+
+	"henar-backend/internal/ical"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+// DejaVu Sans covers Latin, Cyrillic and Armenian
+//
+//go:embed fonts/DejaVuSans.ttf fonts/DejaVuSans-Bold.ttf
+var fonts embed.FS
+
+const fontFamily = "DejaVu"
+
+// Certificate is what is printed on a certificate. Title is in the
+// language the certificate is rendered in.
+type Certificate struct {
+	Name      string
+	Title     string
+	Date      time.Time
+	Organizer string
+	Code      string
+	VerifyURL string
+}
+
+type labels struct {
+	heading   string
+	certifies string
+	attended  string
+	date      string
+	organizer string
+	code      string
+	verify    string
+}
+
+var texts = map[string]labels{
+	"en": {
+		heading:   "Certificate of Attendance",
+		certifies: "This is to certify that",
+		attended:  "attended the event",
+		date:      "Date",
+		organizer: "Organizer",
+		code:      "Verification code",
+		verify:    "Verify at",
+	},
+	"ru": {
+		heading:   "Сертификат участника",
+		certifies: "Настоящим подтверждается, что",
+		attended:  "принял(а) участие в мероприятии",
+		date:      "Дата",
+		organizer: "Организатор",
+		code:      "Код проверки",
+		verify:    "Проверить",
+	},
+	"hy": {
+		heading:   "Մասնակցության վկայական",
+		certifies: "Սույնով հավաստվում է, որ",
+		attended:  "մասնակցել է միջոցառմանը",
+		date:      "Ամսաթիվ",
+		organizer: "Կազմակերպիչ",
+		code:      "Ստուգման կոդ",
+		verify:    "Ստուգել",
+	},
+}
+
+// Render lays the certificate out on a landscape A4 page in the given
+// language, English for languages it isn't written in.
+func Render(certificate Certificate, language string) ([]byte, error) {
+	label, ok := texts[language]
+	if !ok {
+		label = texts["en"]
+	}
+
+	regular, err := fonts.ReadFile("fonts/DejaVuSans.ttf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %w", err)
+	}
+	bold, err := fonts.ReadFile("fonts/DejaVuSans-Bold.ttf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %w", err)
+	}
+
+	pdf := fpdf.New("L", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(fontFamily, "", regular)
+	pdf.AddUTF8FontFromBytes(fontFamily, "B", bold)
+	pdf.SetTitle(label.heading, true)
+	pdf.SetCreator("HealthNet", true)
+	pdf.SetMargins(25, 25, 25)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	width, height := pdf.GetPageSize()
+	contentWidth := width - 50
+
+	pdf.SetDrawColor(40, 90, 140)
+	pdf.SetLineWidth(1.5)
+	pdf.Rect(10, 10, width-20, height-20, "D")
+	pdf.SetLineWidth(0.4)
+	pdf.Rect(14, 14, width-28, height-28, "D")
+
+	pdf.SetTextColor(40, 90, 140)
+	pdf.SetFont(fontFamily, "B", 30)
+	pdf.SetXY(25, 32)
+	pdf.CellFormat(contentWidth, 14, label.heading, "", 1, "C", false, 0, "")
+
+	pdf.SetTextColor(60, 60, 60)
+	pdf.SetFont(fontFamily, "", 14)
+	pdf.Ln(10)
+	pdf.CellFormat(contentWidth, 8, label.certifies, "", 1, "C", false, 0, "")
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont(fontFamily, "B", 26)
+	pdf.Ln(4)
+	pdf.CellFormat(contentWidth, 14, certificate.Name, "", 1, "C", false, 0, "")
+
+	pdf.SetTextColor(60, 60, 60)
+	pdf.SetFont(fontFamily, "", 14)
+	pdf.Ln(4)
+	pdf.CellFormat(contentWidth, 8, label.attended, "", 1, "C", false, 0, "")
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont(fontFamily, "B", 18)
+	pdf.Ln(3)
+	pdf.MultiCell(contentWidth, 9, "«"+certificate.Title+"»", "", "C", false)
+
+	pdf.SetFont(fontFamily, "", 12)
+	pdf.Ln(6)
+	pdf.CellFormat(contentWidth, 7, label.date+": "+certificate.Date.In(ical.Location).Format("02.01.2006"), "", 1, "C", false, 0, "")
+	if certificate.Organizer != "" {
+		pdf.CellFormat(contentWidth, 7, label.organizer+": "+certificate.Organizer, "", 1, "C", false, 0, "")
+	}
+
+	// the code and a QR code of the verification page sit at the bottom
+	qr, err := qrcode.Encode(certificate.VerifyURL, qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode verification link: %w", err)
+	}
+	pdf.RegisterImageOptionsReader("verify", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+	pdf.ImageOptions("verify", width-25-30, height-25-30, 30, 30, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, certificate.VerifyURL)
+
+	pdf.SetTextColor(90, 90, 90)
+	pdf.SetFont(fontFamily, "", 10)
+	pdf.SetXY(25, height-25-12)
+	pdf.CellFormat(contentWidth-35, 6, label.code+": "+certificate.Code, "", 1, "L", false, 0, "")
+	pdf.CellFormat(contentWidth-35, 6, label.verify+": "+certificate.VerifyURL, "", 1, "L", false, 0, certificate.VerifyURL)
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, fmt.Errorf("failed to render certificate: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,146 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"henar-backend/sentry"
+	"henar-backend/types"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const supportEmail = "info@healthnet.am"
+
+// Client composes the transactional emails of the platform and queues them
+// in the outbox for delivery.
+type Client struct {
+	outbox *Outbox
+	host   string
+}
+
+func NewClient(outbox *Outbox) *Client {
+	return &Client{
+		outbox: outbox,
+		host:   "https://healthnet.am",
+	}
+}
+
+// SendEmail queues an email. Delivery happens in the background, so
+// provider errors are not returned here.
+func (c *Client) SendEmail(toEmail, name, subject, textPart, htmlPart string) error {
+	err := c.outbox.Enqueue(context.TODO(), Message{
+		To:      toEmail,
+		ToName:  name,
+		Subject: subject,
+		Text:    textPart,
+		HTML:    htmlPart,
+	})
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	return err
+}
+
+// RetryEmail queues a message that ran out of delivery attempts again.
+func (c *Client) RetryEmail(id primitive.ObjectID) error {
+	return c.outbox.Retry(context.TODO(), id)
+}
+
+// Recipient is the addressee of a templated email.
+type Recipient struct {
+	Email    string
+	Name     string
+	Language string
+}
+
+// UserRecipient addresses an email to the user by their real name in their
+// preferred language.
+func UserRecipient(user types.User) Recipient {
+	return Recipient{
+		Email:    user.Email,
+		Name:     strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Language: user.Language,
+	}
+}
+
+// SendTemplate renders the named template for the recipient and sends it.
+func (c *Client) SendTemplate(name string, to Recipient, data TemplateData) error {
+	data.Name = to.Name
+	data.Host = c.host
+	data.SupportEmail = supportEmail
+
+	rendered, err := Render(name, to.Language, data)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return err
+	}
+
+	return c.SendEmail(to.Email, to.Name, rendered.Subject, rendered.Text, rendered.HTML)
+}
+
+func (c *Client) SendConfirmationEmail(user types.User, verificationData types.VerificationData) error {
+	to := UserRecipient(user)
+	to.Email = verificationData.Email
+
+	return c.SendTemplate(ConfirmationTemplate, to, TemplateData{
+		Link: fmt.Sprintf("%s/verify-email/%s", c.host, verificationData.Code),
+	})
+}
+
+func (c *Client) SendPasswordResetEmail(user types.User, verificationData types.VerificationData) error {
+	to := UserRecipient(user)
+	to.Email = verificationData.Email
+
+	return c.SendTemplate(PasswordResetTemplate, to, TemplateData{
+		Link: fmt.Sprintf("%s/reset-password/%s", c.host, verificationData.Code),
+	})
+}
+
+// NotificationLink points to the project, the event or the person a
+// notification is about.
+func (c *Client) NotificationLink(notification types.Notification) string {
+	switch {
+	case notification.Type == types.EventCancelled:
+		// the event may be gone
+		return fmt.Sprintf("%s/events", c.host)
+	case notification.Body.EventID != "":
+		return fmt.Sprintf("%s/events/%s", c.host, notification.Body.EventID)
+	case notification.Body.ProjectID != "":
+		return fmt.Sprintf("%s/projects/%s", c.host, notification.Body.ProjectID)
+	case !notification.Body.PersonID.IsZero():
+		return fmt.Sprintf("%s/users/%s", c.host, notification.Body.PersonID.Hex())
+	}
+
+	return c.host
+}
+
+func (c *Client) SendNotificationEmail(user types.User, notification types.Notification) error {
+	return c.SendTemplate(NotificationTemplate, UserRecipient(user), TemplateData{
+		Link:         c.NotificationLink(notification),
+		Notification: notification,
+	})
+}
+
+func (c *Client) digestItems(notifications []types.Notification) []DigestItem {
+	items := make([]DigestItem, 0, len(notifications))
+	for _, notification := range notifications {
+		items = append(items, DigestItem{
+			Notification: notification,
+			Link:         c.NotificationLink(notification),
+		})
+	}
+
+	return items
+}
+
+// SendDigestEmail sums up the given unread notifications in one email.
+func (c *Client) SendDigestEmail(user types.User, frequency types.DigestFrequency, notifications []types.Notification) error {
+	return c.SendTemplate(DigestTemplate, UserRecipient(user), TemplateData{
+		Link:            c.host,
+		DigestFrequency: frequency,
+		Digest:          c.digestItems(notifications),
+	})
+}
@@ -0,0 +1,173 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"henar-backend/db"
+	"henar-backend/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type EventType string
+
+const (
+	BounceEvent  EventType = "bounce"
+	SpamEvent    EventType = "spam"
+	BlockedEvent EventType = "blocked"
+)
+
+// DeliveryEvent is a delivery problem reported by the email provider.
+type DeliveryEvent struct {
+	Type       EventType
+	Email      string
+	HardBounce bool
+	Reason     string
+	Time       time.Time
+}
+
+// suppressedStatuses are the statuses after which nothing is sent to the
+// address anymore.
+var suppressedStatuses = []types.EmailStatus{types.EmailHardBounced, types.EmailComplained}
+
+// VerifySignature checks the hex encoded HMAC-SHA256 of the request body.
+func VerifySignature(body []byte, signature, secret string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// VerifyBasicAuth checks the password of a webhook URL with credentials, the
+// way Mailjet authenticates its event callbacks.
+func VerifyBasicAuth(password, secret string) bool {
+	if secret == "" || password == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(password), []byte(secret)) == 1
+}
+
+type mailjetEvent struct {
+	Event          string `json:"event"`
+	Time           int64  `json:"time"`
+	Email          string `json:"email"`
+	HardBounce     bool   `json:"hard_bounce"`
+	Error          string `json:"error"`
+	ErrorRelatedTo string `json:"error_related_to"`
+	Source         string `json:"source"`
+}
+
+// ParseMailjetEvents reads a Mailjet event callback, which is either a
+// single event or a batch. Events other than bounces, spam complaints and
+// blocked messages are ignored.
+func ParseMailjetEvents(body []byte) ([]DeliveryEvent, error) {
+	var raw []mailjetEvent
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("invalid event batch: %w", err)
+		}
+	} else {
+		var event mailjetEvent
+		if err := json.Unmarshal(trimmed, &event); err != nil {
+			return nil, fmt.Errorf("invalid event: %w", err)
+		}
+		raw = []mailjetEvent{event}
+	}
+
+	var events []DeliveryEvent
+	for _, e := range raw {
+		if e.Email == "" {
+			continue
+		}
+
+		event := DeliveryEvent{
+			Type:       EventType(e.Event),
+			Email:      e.Email,
+			HardBounce: e.HardBounce,
+			Time:       time.Unix(e.Time, 0),
+		}
+		if e.Time == 0 {
+			event.Time = time.Now()
+		}
+
+		switch event.Type {
+		case BounceEvent, BlockedEvent:
+			event.Reason = strings.TrimSpace(e.ErrorRelatedTo + " " + e.Error)
+		case SpamEvent:
+			event.Reason = e.Source
+		default:
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// RecordEvent stores the deliverability status the event implies on the
+// user with that address. Hard bounces and spam complaints are never
+// downgraded by later soft bounces or blocks.
+func RecordEvent(ctx context.Context, event DeliveryEvent) error {
+	collection, _ := db.GetCollection("users")
+
+	filter := bson.M{"user_credentials.email": event.Email}
+	set := bson.M{
+		"email_deliverability.reason":     event.Reason,
+		"email_deliverability.updated_at": event.Time,
+	}
+	update := bson.M{"$set": set}
+
+	switch {
+	case event.Type == SpamEvent:
+		set["email_deliverability.status"] = types.EmailComplained
+	case event.Type == BounceEvent && event.HardBounce:
+		set["email_deliverability.status"] = types.EmailHardBounced
+	default:
+		set["email_deliverability.status"] = types.EmailSoftBounced
+		if event.Type == BlockedEvent {
+			set["email_deliverability.status"] = types.EmailBlocked
+		}
+		update["$inc"] = bson.M{"email_deliverability.soft_bounces": 1}
+		filter["email_deliverability.status"] = bson.M{"$nin": suppressedStatuses}
+	}
+
+	_, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to record %s event: %w", event.Type, err)
+	}
+
+	return nil
+}
+
+// isSuppressed reports whether sending to the address is pointless because
+// it hard bounced or its owner reported spam.
+func isSuppressed(ctx context.Context, email string) (bool, error) {
+	collection, _ := db.GetCollection("users")
+
+	count, err := collection.CountDocuments(ctx, bson.M{
+		"user_credentials.email":      email,
+		"email_deliverability.status": bson.M{"$in": suppressedStatuses},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check email status: %w", err)
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Message is a single outgoing email with a plain text and an HTML part.
+type Message struct {
+	To      string
+	ToName  string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Sender struct {
+	Email string
+	Name  string
+}
+
+// Mailer delivers messages through one email provider.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Init creates the mailer selected by EMAIL_DRIVER: mailjet (default),
+// smtp, file or memory. It is meant to be called once at startup.
+func Init() (Mailer, error) {
+	driver := os.Getenv("EMAIL_DRIVER")
+
+	switch driver {
+	case "", "mailjet":
+		return NewMailjetMailer(os.Getenv("MAILJET_APIKEY_PUBLIC"), os.Getenv("MAILJET_APIKEY_PRIVATE"))
+	case "smtp":
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     senderFromEnv(),
+		})
+	case "file":
+		dir := os.Getenv("EMAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir, senderFromEnv())
+	case "memory":
+		return NewMemoryMailer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown email driver: %q", driver)
+}
+
+func senderFromEnv() Sender {
+	sender := Sender{
+		Email: os.Getenv("EMAIL_FROM"),
+		Name:  os.Getenv("EMAIL_FROM_NAME"),
+	}
+	if sender.Email == "" {
+		sender.Email = "info@healthnet.am"
+	}
+	if sender.Name == "" {
+		sender.Name = "HealthNet"
+	}
+
+	return sender
+}
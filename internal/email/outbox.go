@@ -0,0 +1,271 @@
+package email
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"henar-backend/db"
+	"henar-backend/internal/scheduler"
+	"henar-backend/sentry"
+	"henar-backend/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection   = "email_outbox"
+	defaultMaxAttempts = 8
+	retryBaseDelay     = time.Minute
+	retryMaxDelay      = 6 * time.Hour
+	// a claimed message whose worker died is picked up again after this
+	sendLease = 5 * time.Minute
+	// identical messages enqueued within this window are sent once
+	dedupWindow = 10 * time.Minute
+)
+
+// Outbox stores outgoing mail in the email_outbox collection and delivers
+// it in the background, retrying failed sends with exponential backoff.
+type Outbox struct {
+	mailer      Mailer
+	maxAttempts int
+	wake        chan struct{}
+}
+
+// NewOutbox creates an outbox delivering through mailer. The number of
+// attempts per message is read from EMAIL_MAX_ATTEMPTS.
+func NewOutbox(mailer Mailer) *Outbox {
+	maxAttempts, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS"))
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	return &Outbox{
+		mailer:      mailer,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// messageKey identifies a message by its content within a dedupWindow
+// bucket. Enqueueing the same message twice in a short time, e.g. from a
+// retried request, stores and sends it once, while the same email sent
+// again later gets a key of its own.
+func messageKey(msg Message, now time.Time) string {
+	hash := sha256.New()
+	for _, part := range []string{msg.To, msg.Subject, msg.Text, msg.HTML} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	bucket := now.Truncate(dedupWindow).Unix()
+	return hex.EncodeToString(hash.Sum(nil)) + "/" + strconv.FormatInt(bucket, 10)
+}
+
+// Enqueue stores the message for delivery and wakes the worker. A message
+// that was just enqueued is not stored again; it only counts as enqueued
+// while that copy is still going to be or was sent.
+func (o *Outbox) Enqueue(ctx context.Context, msg Message) error {
+	collection, _ := db.GetCollection(outboxCollection)
+
+	now := time.Now()
+	email := types.OutboxEmail{
+		ID:            primitive.NewObjectID(),
+		Key:           messageKey(msg, now),
+		To:            msg.To,
+		ToName:        msg.ToName,
+		Subject:       msg.Subject,
+		Text:          msg.Text,
+		HTML:          msg.HTML,
+		Status:        types.OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	_, err := collection.InsertOne(ctx, email)
+	if mongo.IsDuplicateKeyError(err) {
+		return duplicateStatus(ctx, email.Key)
+	}
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to enqueue email")
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// duplicateStatus reports whether the message already stored under key
+// will still be delivered.
+func duplicateStatus(ctx context.Context, key string) error {
+	collection, _ := db.GetCollection(outboxCollection)
+
+	var existing types.OutboxEmail
+	err := collection.FindOne(ctx, bson.M{"key": key}).Decode(&existing)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to enqueue email")
+	}
+
+	switch existing.Status {
+	case types.OutboxPending, types.OutboxSending, types.OutboxSent:
+		return nil
+	}
+
+	return fmt.Errorf("identical email %s was not delivered: %s", existing.ID.Hex(), existing.Status)
+}
+
+// Retry puts a failed message back in the queue with a fresh attempt count.
+func (o *Outbox) Retry(ctx context.Context, id primitive.ObjectID) error {
+	collection, _ := db.GetCollection(outboxCollection)
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": types.OutboxFailed},
+		bson.M{
+			"$set":   bson.M{"status": types.OutboxPending, "attempts": 0, "next_attempt_at": time.Now()},
+			"$unset": bson.M{"last_error": ""},
+		},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to retry email")
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// claim takes the next due message and leases it to this worker.
+func (o *Outbox) claim(ctx context.Context) (*types.OutboxEmail, error) {
+	collection, _ := db.GetCollection(outboxCollection)
+
+	now := time.Now()
+	filter := bson.M{
+		"status":          bson.M{"$in": []types.OutboxStatus{types.OutboxPending, types.OutboxSending}},
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": types.OutboxSending, "next_attempt_at": now.Add(sendLease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	var email types.OutboxEmail
+	err := collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&email)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim email: %w", err)
+	}
+
+	return &email, nil
+}
+
+// backoff is the delay before the next attempt after the given number of
+// failed attempts: one minute, doubling up to six hours.
+func backoff(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, retryMaxDelay)
+}
+
+func (o *Outbox) deliver(ctx context.Context, email types.OutboxEmail) error {
+	collection, _ := db.GetCollection(outboxCollection)
+
+	suppressed, err := isSuppressed(ctx, email.To)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": email.ID},
+			bson.M{"$set": bson.M{"status": types.OutboxSuppressed}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update email %s: %w", email.ID.Hex(), err)
+		}
+		return nil
+	}
+
+	sendErr := o.mailer.Send(ctx, Message{
+		To:      email.To,
+		ToName:  email.ToName,
+		Subject: email.Subject,
+		Text:    email.Text,
+		HTML:    email.HTML,
+	})
+
+	now := time.Now()
+	var update bson.M
+	switch {
+	case sendErr == nil:
+		update = bson.M{
+			"$set":   bson.M{"status": types.OutboxSent, "sent_at": now},
+			"$unset": bson.M{"last_error": ""},
+		}
+	case email.Attempts >= o.maxAttempts:
+		sentry.SentryHandler(sendErr)
+		update = bson.M{"$set": bson.M{"status": types.OutboxFailed, "last_error": sendErr.Error()}}
+	default:
+		update = bson.M{"$set": bson.M{
+			"status":          types.OutboxPending,
+			"last_error":      sendErr.Error(),
+			"next_attempt_at": now.Add(backoff(email.Attempts)),
+		}}
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": email.ID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update email %s: %w", email.ID.Hex(), err)
+	}
+
+	return nil
+}
+
+// Process sends every message that is due.
+func (o *Outbox) Process(ctx context.Context) error {
+	for {
+		email, err := o.claim(ctx)
+		if err != nil {
+			return err
+		}
+		if email == nil {
+			return nil
+		}
+
+		if err := o.deliver(ctx, *email); err != nil {
+			return err
+		}
+	}
+}
+
+// Start runs the delivery worker until the context is cancelled. It checks
+// for due messages every 30 seconds and right after a message is enqueued.
+func (o *Outbox) Start(ctx context.Context) {
+	scheduler.Triggered(ctx, "email-outbox", 30*time.Second, o.wake, o.Process)
+}
@@ -0,0 +1,49 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     Sender
+}
+
+// SMTPMailer sends mail through a plain SMTP server, using STARTTLS when the
+// server offers it.
+type SMTPMailer struct {
+	config SMTPConfig
+	auth   smtp.Auth
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	if config.Host == "" {
+		return nil, errors.New("SMTP_HOST is required for the smtp email driver")
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+
+	return &SMTPMailer{config: config, auth: auth}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	body, err := buildMIME(m.config.From, msg)
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(m.config.Host, m.config.Port)
+	return smtp.SendMail(address, m.auth, m.config.From.Email, []string{msg.To}, body)
+}
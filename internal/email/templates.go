@@ -0,0 +1,250 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"henar-backend/internal/ical"
+	"henar-backend/types"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+const (
+	ConfirmationTemplate  = "confirmation"
+	PasswordResetTemplate = "password_reset"
+	NotificationTemplate  = "notification"
+	DigestTemplate        = "digest"
+)
+
+const defaultLanguage = "en"
+
+var (
+	languages     = []string{"en", "ru", "hy"}
+	templateNames = []string{ConfirmationTemplate, PasswordResetTemplate, NotificationTemplate, DigestTemplate}
+)
+
+// TemplateData is what every email template is rendered with. Subject is
+// filled in from the template itself before the layout is executed.
+type TemplateData struct {
+	Name         string
+	Link         string
+	Host         string
+	SupportEmail string
+	Language     string
+	Subject      string
+	Notification types.Notification
+
+	DigestFrequency types.DigestFrequency
+	Digest          []DigestItem
+}
+
+// DigestItem is one notification listed in a digest.
+type DigestItem struct {
+	Notification types.Notification
+	Link         string
+}
+
+// Rendered is an email template executed for one recipient.
+type Rendered struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+type emailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// templateFuncs help messages agree with the number of people involved.
+var templateFuncs = map[string]any{
+	// many reports whether a notification has more than one actor
+	"many": func(actors []types.NotificationActor) bool {
+		return len(actors) > 1
+	},
+	// others counts the actors besides the first one
+	"others": func(actors []types.NotificationActor) int {
+		return max(len(actors)-1, 0)
+	},
+	// eventTime formats the time of an event as it is in Yerevan
+	"eventTime": func(t *time.Time) string {
+		return t.In(ical.Location).Format("02.01.2006 15:04")
+	},
+	// plural picks the Russian form for n: one, few or many
+	"plural": func(n int, one, few, many string) string {
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return one
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return few
+		}
+		return many
+	},
+}
+
+// every template is parsed once per language together with the shared
+// layout, the language's greeting and signature and its messages, which
+// are plain text and shared by both formats
+var (
+	templates = parseTemplates()
+	messages  = parseMessages()
+)
+
+func parseTemplates() map[string]map[string]emailTemplate {
+	parsed := make(map[string]map[string]emailTemplate)
+
+	for _, language := range languages {
+		parsed[language] = make(map[string]emailTemplate)
+		for _, name := range templateNames {
+			html := htmltemplate.Must(htmltemplate.New(name).Funcs(templateFuncs).ParseFS(templateFiles,
+				"templates/layout.html",
+				"templates/"+language+"/common.html",
+				"templates/"+language+"/messages.tmpl",
+				"templates/"+language+"/"+name+".html",
+			))
+			text := texttemplate.Must(texttemplate.New(name).Funcs(templateFuncs).ParseFS(templateFiles,
+				"templates/layout.txt",
+				"templates/"+language+"/common.txt",
+				"templates/"+language+"/messages.tmpl",
+				"templates/"+language+"/"+name+".txt",
+			))
+			parsed[language][name] = emailTemplate{html: html, text: text}
+		}
+	}
+
+	return parsed
+}
+
+// parseMessages parses the messages of every language on their own, for
+// text shown outside of emails.
+func parseMessages() map[string]*texttemplate.Template {
+	parsed := make(map[string]*texttemplate.Template)
+
+	for _, language := range languages {
+		parsed[language] = texttemplate.Must(texttemplate.New("messages").Funcs(templateFuncs).ParseFS(templateFiles,
+			"templates/"+language+"/messages.tmpl",
+		))
+	}
+
+	return parsed
+}
+
+// NotificationText renders the message of a notification in the given
+// language, as shown in the inbox and in notification emails.
+func NotificationText(language string, notification types.Notification) (string, error) {
+	var text bytes.Buffer
+	err := messages[Language(language)].ExecuteTemplate(&text, "notification_message", TemplateData{
+		Notification: notification,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s notification: %w", notification.Type, err)
+	}
+
+	return text.String(), nil
+}
+
+// Language maps a user's language setting to one of the languages emails
+// are written in, falling back to English.
+func Language(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if len(language) > 2 {
+		language = language[:2]
+	}
+
+	for _, supported := range languages {
+		if language == supported {
+			return language
+		}
+	}
+
+	return defaultLanguage
+}
+
+// Templates lists the names of all email templates.
+func Templates() []string {
+	return append([]string(nil), templateNames...)
+}
+
+// Render executes the named template in the given language.
+func Render(name, language string, data TemplateData) (Rendered, error) {
+	data.Language = Language(language)
+
+	tmpl, ok := templates[data.Language][name]
+	if !ok {
+		return Rendered{}, fmt.Errorf("unknown email template: %q", name)
+	}
+
+	var subject bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Rendered{}, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	data.Subject = strings.TrimSpace(subject.String())
+
+	var text bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&text, "layout", data); err != nil {
+		return Rendered{}, fmt.Errorf("failed to render %s text: %w", name, err)
+	}
+
+	var html bytes.Buffer
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Rendered{}, fmt.Errorf("failed to render %s html: %w", name, err)
+	}
+
+	return Rendered{
+		Subject: data.Subject,
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// SampleData returns placeholder data for previewing the named template.
+func (c *Client) SampleData(name string) TemplateData {
+	data := TemplateData{
+		Name:         "Anna Petrosyan",
+		Host:         c.host,
+		SupportEmail: supportEmail,
+	}
+
+	switch name {
+	case ConfirmationTemplate:
+		data.Link = c.host + "/verify-email/sample-code"
+	case PasswordResetTemplate:
+		data.Link = c.host + "/reset-password/sample-code"
+	case NotificationTemplate:
+		data.Notification = types.Notification{
+			Type: types.ContactsRequested,
+			Body: types.NotificationBody{
+				PersonID:       primitive.NewObjectID(),
+				PersonFullName: "Aram Hakobyan",
+			},
+		}
+		data.Link = c.NotificationLink(data.Notification)
+	case DigestTemplate:
+		data.DigestFrequency = types.DigestDaily
+		data.Digest = c.digestItems([]types.Notification{
+			{
+				Type: types.ContactsRequested,
+				Body: types.NotificationBody{PersonID: primitive.NewObjectID(), PersonFullName: "Aram Hakobyan"},
+			},
+			{
+				Type: types.ProjectRequest,
+				Body: types.NotificationBody{PersonID: primitive.NewObjectID(), PersonFullName: "Lilit Sargsyan"},
+			},
+			{
+				Type: types.ApproveApplicant,
+				Body: types.NotificationBody{ProjectID: "sample-project", ProjectTitle: "Rural Telemedicine"},
+			},
+		})
+	}
+
+	return data
+}
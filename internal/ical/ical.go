@@ -0,0 +1,188 @@
+// Package ical writes iCalendar (RFC 5545) files.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	// calendars are written in Yerevan time even where the system has no
+	// zoneinfo database
+	_ "time/tzdata"
+)
+
+// TimeZone is the zone events take place in.
+const TimeZone = "Asia/Yerevan"
+
+// Location is TimeZone as a time.Location.
+var Location = mustLoadLocation(TimeZone)
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+
+	return location
+}
+
+// Armenia has kept +04:00 without daylight saving time since 2012.
+const vtimezone = `BEGIN:VTIMEZONE
+TZID:Asia/Yerevan
+X-LIC-LOCATION:Asia/Yerevan
+BEGIN:STANDARD
+TZOFFSETFROM:+0400
+TZOFFSETTO:+0400
+TZNAME:+04
+DTSTART:19700101T000000
+END:STANDARD
+END:VTIMEZONE`
+
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Event is one VEVENT. End defaults to Start plus DefaultDuration.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Start       time.Time
+	End         time.Time
+	Status      string
+	Modified    time.Time
+	// RRule and ExDates repeat the event, RecurrenceID marks an event
+	// that replaces one occurrence of the series with the same UID
+	RRule        string
+	ExDates      []time.Time
+	RecurrenceID time.Time
+}
+
+// DefaultDuration is used for events without an end.
+const DefaultDuration = 2 * time.Hour
+
+// Calendar is a VCALENDAR. Name is shown by calendar apps subscribed to it.
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Encode writes the calendar with CRLF line endings and long lines folded.
+func (c Calendar) Encode(w io.Writer) error {
+	e := encoder{w: w}
+
+	e.line("BEGIN:VCALENDAR")
+	e.line("VERSION:2.0")
+	e.line("PRODID:-//HealthNet//Events//EN")
+	e.line("CALSCALE:GREGORIAN")
+	e.line("METHOD:PUBLISH")
+	if c.Name != "" {
+		e.line("X-WR-CALNAME:" + Escape(c.Name))
+	}
+	e.line("X-WR-TIMEZONE:" + TimeZone)
+	e.line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	e.line("X-PUBLISHED-TTL:PT1H")
+	for _, line := range strings.Split(vtimezone, "\n") {
+		e.line(line)
+	}
+
+	stamp := time.Now().UTC().Format(utcFormat)
+	for _, event := range c.Events {
+		end := event.End
+		if end.IsZero() {
+			end = event.Start.Add(DefaultDuration)
+		}
+
+		e.line("BEGIN:VEVENT")
+		e.line("UID:" + event.UID)
+		e.line("DTSTAMP:" + stamp)
+		e.line("DTSTART;TZID=" + TimeZone + ":" + event.Start.In(Location).Format(localFormat))
+		e.line("DTEND;TZID=" + TimeZone + ":" + end.In(Location).Format(localFormat))
+		if event.RRule != "" {
+			e.line("RRULE:" + strings.TrimPrefix(event.RRule, "RRULE:"))
+		}
+		for _, exdate := range event.ExDates {
+			e.line("EXDATE;TZID=" + TimeZone + ":" + exdate.In(Location).Format(localFormat))
+		}
+		if !event.RecurrenceID.IsZero() {
+			e.line("RECURRENCE-ID;TZID=" + TimeZone + ":" + event.RecurrenceID.In(Location).Format(localFormat))
+		}
+		e.line("SUMMARY:" + Escape(event.Summary))
+		if event.Description != "" {
+			e.line("DESCRIPTION:" + Escape(event.Description))
+		}
+		if event.Location != "" {
+			e.line("LOCATION:" + Escape(event.Location))
+		}
+		if event.URL != "" {
+			e.line("URL:" + event.URL)
+		}
+		if event.Status != "" {
+			e.line("STATUS:" + event.Status)
+		}
+		if !event.Modified.IsZero() {
+			e.line("LAST-MODIFIED:" + event.Modified.UTC().Format(utcFormat))
+		}
+		e.line("END:VEVENT")
+	}
+
+	e.line("END:VCALENDAR")
+
+	return e.err
+}
+
+func (c Calendar) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Encode(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+const (
+	utcFormat   = "20060102T150405Z"
+	localFormat = "20060102T150405"
+)
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Escape escapes a TEXT value.
+func Escape(text string) string {
+	return escaper.Replace(text)
+}
+
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+// line writes a content line, folded into 75 octet pieces without
+// splitting UTF-8 sequences.
+func (e *encoder) line(line string) {
+	if e.err != nil {
+		return
+	}
+
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if _, e.err = fmt.Fprintf(e.w, "%s\r\n ", line[:cut]); e.err != nil {
+			return
+		}
+		line = line[cut:]
+		// continuation lines start with the folding space
+		limit = 74
+	}
+
+	_, e.err = fmt.Fprintf(e.w, "%s\r\n", line)
+}
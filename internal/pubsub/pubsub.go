@@ -0,0 +1,71 @@
+package pubsub
+
+import "sync"
+
+// Message is published to every subscriber of its topic.
+type Message struct {
+	Topic string
+	Event string
+	Data  []byte
+}
+
+// Broker fans messages out to subscribers. The in-process Memory broker
+// only reaches subscribers of the same instance; a shared broker can
+// implement the same interface once the API runs on several instances.
+type Broker interface {
+	Publish(msg Message)
+	// Subscribe returns the messages of a topic and a function that ends
+	// the subscription and closes the channel.
+	Subscribe(topic string) (<-chan Message, func())
+}
+
+// subscriberBuffer is how many messages a slow subscriber may fall behind
+// before further messages are dropped for it.
+const subscriberBuffer = 16
+
+type Memory struct {
+	mu     sync.RWMutex
+	topics map[string]map[chan Message]struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{topics: make(map[string]map[chan Message]struct{})}
+}
+
+func (m *Memory) Publish(msg Message) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for ch := range m.topics[msg.Topic] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+func (m *Memory) Subscribe(topic string) (<-chan Message, func()) {
+	ch := make(chan Message, subscriberBuffer)
+
+	m.mu.Lock()
+	if m.topics[topic] == nil {
+		m.topics[topic] = make(map[chan Message]struct{})
+	}
+	m.topics[topic][ch] = struct{}{}
+	m.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.topics[topic], ch)
+			if len(m.topics[topic]) == 0 {
+				delete(m.topics, topic)
+			}
+			m.mu.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
@@ -0,0 +1,90 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrInvalidEndpoint is returned for endpoints that are not a public https
+// URL. Push requests are sent from the server, so an endpoint pointing into
+// the private network would let users make it call internal services.
+var ErrInvalidEndpoint = errors.New("push endpoint must be a public https URL")
+
+// publicIP reports whether the address is reachable on the internet, i.e.
+// not loopback, private, link-local or otherwise reserved.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// ValidateEndpoint checks that the endpoint of a subscription is an https
+// URL whose host resolves to public addresses only.
+func (c *Client) ValidateEndpoint(ctx context.Context, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" || u.User != nil {
+		return ErrInvalidEndpoint
+	}
+	if c.allowLocal {
+		return nil
+	}
+	if u.Scheme != "https" {
+		return ErrInvalidEndpoint
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: %s does not resolve", ErrInvalidEndpoint, u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrInvalidEndpoint
+		}
+	}
+
+	return nil
+}
+
+// publicOnlyClient only connects to public addresses, so a host that
+// resolved to a public address when it was subscribed can't be pointed at
+// the private network later.
+func publicOnlyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return ErrInvalidEndpoint
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		// a redirect would skip ValidateEndpoint
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// AllowLocalEndpoints lets the client push to local plain http endpoints,
+// e.g. the StubServer in development.
+func (c *Client) AllowLocalEndpoints() {
+	c.allowLocal = true
+	c.httpClient = &http.Client{Timeout: 30 * time.Second}
+}
@@ -0,0 +1,108 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+// ErrGone is returned when the push service no longer knows the
+// subscription, it should be forgotten.
+var ErrGone = errors.New("push subscription expired")
+
+// Subscription is a browser PushSubscription as returned by
+// PushManager.subscribe.
+type Subscription = webpush.Subscription
+
+// Keys are the base64 encoded p256dh and auth values of a subscription.
+type Keys = webpush.Keys
+
+// Message is the payload the service worker receives.
+type Message struct {
+	ID    string `json:"id,omitempty"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+}
+
+// Client sends encrypted Web Push messages signed with the server's VAPID
+// keys.
+type Client struct {
+	PublicKey  string
+	privateKey string
+	subject    string
+	httpClient webpush.HTTPClient
+	allowLocal bool
+}
+
+func NewClient(publicKey, privateKey, subject string) *Client {
+	return &Client{
+		PublicKey:  publicKey,
+		privateKey: privateKey,
+		subject:    subject,
+		httpClient: publicOnlyClient(),
+	}
+}
+
+// Init creates a client from VAPID_PUBLIC_KEY, VAPID_PRIVATE_KEY and
+// VAPID_SUBJECT, a contact email or https URL. Without keys push is
+// disabled and Init returns nil. PUSH_ALLOW_LOCAL_ENDPOINTS=true allows
+// local endpoints for development.
+func Init() *Client {
+	publicKey := os.Getenv("VAPID_PUBLIC_KEY")
+	privateKey := os.Getenv("VAPID_PRIVATE_KEY")
+	if publicKey == "" || privateKey == "" {
+		return nil
+	}
+
+	subject := os.Getenv("VAPID_SUBJECT")
+	if subject == "" {
+		subject = "info@healthnet.am"
+	}
+
+	client := NewClient(publicKey, privateKey, subject)
+	if os.Getenv("PUSH_ALLOW_LOCAL_ENDPOINTS") == "true" {
+		client.AllowLocalEndpoints()
+	}
+
+	return client
+}
+
+// Send encrypts the message for the subscription and hands it to its push
+// service.
+func (c *Client) Send(ctx context.Context, subscription Subscription, message Message) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	resp, err := webpush.SendNotificationWithContext(ctx, payload, &subscription, &webpush.Options{
+		HTTPClient:      c.httpClient,
+		Subscriber:      c.subject,
+		VAPIDPublicKey:  c.PublicKey,
+		VAPIDPrivateKey: c.privateKey,
+		TTL:             int((24 * time.Hour).Seconds()),
+		Urgency:         webpush.UrgencyNormal,
+	})
+	if err != nil {
+		return fmt.Errorf("push: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push: %s: %s", resp.Status, body)
+	}
+
+	return nil
+}
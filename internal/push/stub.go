@@ -0,0 +1,234 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// StubServer is a minimal push service stand-in for local development and
+// tests. It hands out subscriptions with real keys, decrypts what is pushed
+// to them and answers 410 Gone for subscriptions that were expired.
+type StubServer struct {
+	listener net.Listener
+	server   *http.Server
+
+	mu            sync.Mutex
+	subscriptions map[string]*stubSubscription
+	received      []Received
+}
+
+// Received is a message delivered to the stub.
+type Received struct {
+	Endpoint      string
+	Authorization string
+	TTL           string
+	Message       Message
+}
+
+type stubSubscription struct {
+	key     *ecdh.PrivateKey
+	auth    []byte
+	expired bool
+}
+
+// NewStubServer listens on a random local port.
+func NewStubServer() (*StubServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StubServer{listener: listener, subscriptions: map[string]*stubSubscription{}}
+	s.server = &http.Server{Handler: http.HandlerFunc(s.handle)}
+	go s.server.Serve(listener)
+
+	return s, nil
+}
+
+func (s *StubServer) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+func (s *StubServer) Close() error {
+	return s.server.Close()
+}
+
+// Subscribe creates a subscription the way a browser would.
+func (s *StubServer) Subscribe() (Subscription, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return Subscription{}, err
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		return Subscription{}, err
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	endpoint := s.URL() + "/push/" + base64.RawURLEncoding.EncodeToString(id)
+
+	s.mu.Lock()
+	s.subscriptions[endpoint] = &stubSubscription{key: key, auth: auth}
+	s.mu.Unlock()
+
+	return Subscription{
+		Endpoint: endpoint,
+		Keys: Keys{
+			Auth:   base64.RawURLEncoding.EncodeToString(auth),
+			P256dh: base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		},
+	}, nil
+}
+
+// Expire makes the stub answer 410 Gone for the subscription from now on.
+func (s *StubServer) Expire(endpoint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscription, ok := s.subscriptions[endpoint]; ok {
+		subscription.expired = true
+	}
+}
+
+// Received returns the messages delivered so far.
+func (s *StubServer) Received() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Received(nil), s.received...)
+}
+
+func (s *StubServer) handle(w http.ResponseWriter, r *http.Request) {
+	endpoint := s.URL() + r.URL.Path
+
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[endpoint]
+	s.mu.Unlock()
+
+	switch {
+	case r.Method != http.MethodPost:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case subscription.expired:
+		w.WriteHeader(http.StatusGone)
+		return
+	case !strings.HasPrefix(r.Header.Get("Authorization"), "vapid t="):
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	plaintext, err := subscription.decrypt(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var message Message
+	if err := json.Unmarshal(plaintext, &message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.received = append(s.received, Received{
+		Endpoint:      endpoint,
+		Authorization: r.Header.Get("Authorization"),
+		TTL:           r.Header.Get("TTL"),
+		Message:       message,
+	})
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// decrypt reverses the aes128gcm content encoding of RFC 8291 for a
+// payload that fits in one record.
+func (s *stubSubscription) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("payload too short")
+	}
+	salt := body[:16]
+	keyLength := int(body[20])
+	if len(body) < 21+keyLength {
+		return nil, errors.New("payload too short")
+	}
+	senderKey := body[21 : 21+keyLength]
+	ciphertext := body[21+keyLength:]
+
+	sender, err := ecdh.P256().NewPublicKey(senderKey)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.key.ECDH(sender)
+	if err != nil {
+		return nil, err
+	}
+
+	info := append([]byte("WebPush: info\x00"), s.key.PublicKey().Bytes()...)
+	info = append(info, senderKey...)
+	ikm, err := derive(secret, s.auth, info, 32)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := derive(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := derive(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last record ends with a 0x02 delimiter followed by padding
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		return nil, errors.New("missing padding delimiter")
+	}
+
+	return plaintext[:len(plaintext)-1], nil
+}
+
+func derive(secret, salt, info []byte, length int) ([]byte, error) {
+	key := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,280 @@
+// Package recurrence expands the subset of RFC 5545 recurrence rules events
+// use: FREQ=DAILY, WEEKLY or MONTHLY with INTERVAL, COUNT, UNTIL and, for
+// weekly rules, BYDAY.
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// maxIterations bounds the expansion of rules without COUNT or UNTIL.
+const maxIterations = 10000
+
+// Rule is a parsed RRULE. A zero Until and Count mean the series never
+// ends.
+type Rule struct {
+	Frequency Frequency
+	Interval  int
+	Count     int
+	Until     time.Time
+	ByDay     []time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+const utcUntilFormat = "20060102T150405Z"
+
+// untilFormats are the floating forms of UNTIL, which are local to the event.
+var untilFormats = []string{"20060102T150405", "20060102"}
+
+// Parse reads a rule such as FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10.
+// Local UNTIL values are read in loc.
+func Parse(rule string, loc *time.Location) (Rule, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	r := Rule{Interval: 1}
+
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Frequency = Frequency(strings.ToUpper(value))
+			if r.Frequency != Daily && r.Frequency != Weekly && r.Frequency != Monthly {
+				return Rule{}, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return Rule{}, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			r.Interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return Rule{}, fmt.Errorf("invalid COUNT %q", value)
+			}
+			r.Count = count
+		case "UNTIL":
+			until, err := parseUntil(value, loc)
+			if err != nil {
+				return Rule{}, err
+			}
+			r.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(strings.ToUpper(value), ",") {
+				weekday, ok := weekdays[day]
+				if !ok {
+					return Rule{}, fmt.Errorf("unsupported BYDAY %q", day)
+				}
+				r.ByDay = append(r.ByDay, weekday)
+			}
+		case "WKST":
+			if strings.ToUpper(value) != "MO" {
+				return Rule{}, fmt.Errorf("unsupported WKST %q", value)
+			}
+		default:
+			return Rule{}, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	switch {
+	case r.Frequency == "":
+		return Rule{}, fmt.Errorf("FREQ is required")
+	case r.Count > 0 && !r.Until.IsZero():
+		return Rule{}, fmt.Errorf("COUNT and UNTIL can't be combined")
+	case len(r.ByDay) > 0 && r.Frequency != Weekly:
+		return Rule{}, fmt.Errorf("BYDAY is only supported with FREQ=WEEKLY")
+	}
+
+	return r, nil
+}
+
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if until, err := time.ParseInLocation(utcUntilFormat, value, time.UTC); err == nil {
+		return until, nil
+	}
+	for _, format := range untilFormats {
+		until, err := time.ParseInLocation(format, value, loc)
+		if err != nil {
+			continue
+		}
+		// a date includes the whole day
+		if format == "20060102" {
+			until = until.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		return until, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid UNTIL %q", value)
+}
+
+// String formats the rule as an RRULE value.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Frequency)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, weekday := range r.ByDay {
+			for name, day := range weekdays {
+				if day == weekday {
+					days = append(days, name)
+				}
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// Each calls fn with every occurrence of a series starting at start, in
+// order, until fn returns false or the series ends. Occurrences keep the
+// wall clock time of start in its location.
+func (r Rule) Each(start time.Time, fn func(time.Time) bool) {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	emitted := 0
+	emit := func(occurrence time.Time) bool {
+		if !r.Until.IsZero() && occurrence.After(r.Until) {
+			return false
+		}
+		if r.Count > 0 && emitted >= r.Count {
+			return false
+		}
+		emitted++
+		return fn(occurrence)
+	}
+
+	year, month, day := start.Date()
+	hour, min, sec := start.Clock()
+	loc := start.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hour, min, sec, start.Nanosecond(), loc)
+	}
+
+	for i := 0; i < maxIterations; i++ {
+		switch r.Frequency {
+		case Daily:
+			if !emit(at(year, month, day+i*interval)) {
+				return
+			}
+		case Weekly:
+			if len(r.ByDay) == 0 {
+				if !emit(at(year, month, day+7*i*interval)) {
+					return
+				}
+				continue
+			}
+
+			// weeks start on Monday
+			monday := day - (int(start.Weekday())+6)%7 + 7*i*interval
+			days := make([]int, 0, len(r.ByDay))
+			for _, weekday := range r.ByDay {
+				days = append(days, (int(weekday)+6)%7)
+			}
+			sort.Ints(days)
+			for _, offset := range days {
+				occurrence := at(year, month, monday+offset)
+				if occurrence.Before(start) {
+					continue
+				}
+				if !emit(occurrence) {
+					return
+				}
+			}
+		case Monthly:
+			occurrence := at(year, month+time.Month(i*interval), day)
+			// months without this day are skipped
+			if occurrence.Day() != day {
+				continue
+			}
+			if !emit(occurrence) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Between returns the occurrences in [from, to).
+func (r Rule) Between(start, from, to time.Time) []time.Time {
+	occurrences := []time.Time{}
+	r.Each(start, func(occurrence time.Time) bool {
+		if !occurrence.Before(to) {
+			return false
+		}
+		if !occurrence.Before(from) {
+			occurrences = append(occurrences, occurrence)
+		}
+		return true
+	})
+
+	return occurrences
+}
+
+// Includes reports whether t is an occurrence of the series.
+func (r Rule) Includes(start, t time.Time) bool {
+	found := false
+	r.Each(start, func(occurrence time.Time) bool {
+		if occurrence.Equal(t) {
+			found = true
+		}
+		return occurrence.Before(t)
+	})
+
+	return found
+}
+
+// Last returns the final occurrence of a series that ends, and false for
+// one that repeats forever.
+func (r Rule) Last(start time.Time) (time.Time, bool) {
+	if r.Count == 0 && r.Until.IsZero() {
+		return time.Time{}, false
+	}
+
+	var last time.Time
+	r.Each(start, func(occurrence time.Time) bool {
+		last = occurrence
+		return true
+	})
+
+	return last, true
+}
@@ -0,0 +1,38 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Result is the verdict of a scan. Signature names the detected threat when
+// the file is not clean.
+type Result struct {
+	Clean     bool
+	Signature string
+}
+
+// Scanner inspects uploaded content before it is committed to storage.
+type Scanner interface {
+	Scan(ctx context.Context, content io.Reader) (Result, error)
+}
+
+// Noop accepts every file, used when no scanner is configured.
+type Noop struct{}
+
+func (Noop) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	return Result{Clean: true}, nil
+}
+
+// Init returns a clamd scanner when CLAMD_ADDRESS is set and a Noop scanner
+// otherwise. The second value reports whether files are actually scanned.
+func Init() (Scanner, bool) {
+	address := os.Getenv("CLAMD_ADDRESS")
+	if address == "" {
+		return Noop{}, false
+	}
+
+	return NewClamd(address, 30*time.Second), true
+}
@@ -0,0 +1,109 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+)
+
+// EICAR is the standard anti-virus test string.
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// StubServer is a minimal clamd stand-in for local development and tests.
+// It answers PING and INSTREAM and reports a stream as infected when it
+// contains one of the configured patterns.
+type StubServer struct {
+	listener   net.Listener
+	signatures map[string]string
+}
+
+// NewStubServer listens on a random local port. Signatures map a pattern to
+// the name reported for it, nil detects only the EICAR test string.
+func NewStubServer(signatures map[string]string) (*StubServer, error) {
+	if signatures == nil {
+		signatures = map[string]string{EICAR: "Eicar-Signature"}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StubServer{listener: listener, signatures: signatures}
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *StubServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *StubServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *StubServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *StubServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+	command = strings.TrimPrefix(strings.TrimSuffix(command, "\x00"), "z")
+
+	switch command {
+	case "PING":
+		conn.Write([]byte("PONG\x00"))
+	case "INSTREAM":
+		content, err := readStream(reader)
+		if err != nil {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			return
+		}
+		conn.Write([]byte("stream: " + s.verdict(content) + "\x00"))
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func readStream(reader io.Reader) ([]byte, error) {
+	var content bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, size); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			return content.Bytes(), nil
+		}
+		if _, err := io.CopyN(&content, reader, int64(n)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *StubServer) verdict(content []byte) string {
+	for pattern, name := range s.signatures {
+		if bytes.Contains(content, []byte(pattern)) {
+			return name + " FOUND"
+		}
+	}
+
+	return "OK"
+}
@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"henar-backend/db"
+	"henar-backend/sentry"
+	"henar-backend/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	jobsCollection     = "scheduled_jobs"
+	defaultMaxAttempts = 5
+	retryDelay         = 5 * time.Minute
+	// a claimed job whose worker died is picked up again after this
+	jobLease = 10 * time.Minute
+)
+
+// Handler runs one job of a kind. A returned error retries the job later.
+type Handler func(ctx context.Context, job types.ScheduledJob) error
+
+// Queue stores one-off jobs in the scheduled_jobs collection and runs each
+// of them once it is due with the handler registered for its kind.
+type Queue struct {
+	mu          sync.RWMutex
+	handlers    map[string]Handler
+	maxAttempts int
+	wake        chan struct{}
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		handlers:    make(map[string]Handler),
+		maxAttempts: defaultMaxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler of a kind of jobs.
+func (q *Queue) Handle(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.handlers[kind] = handler
+}
+
+func (q *Queue) handler(kind string) (Handler, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	handler, ok := q.handlers[kind]
+	return handler, ok
+}
+
+// Schedule stores a job to run at runAt with the payload, which is
+// marshalled to BSON. A job with the same key is replaced, so scheduling a
+// key again moves the job instead of adding another one.
+func (q *Queue) Schedule(ctx context.Context, key, kind string, runAt time.Time, payload any) error {
+	collection, _ := db.GetCollection(jobsCollection)
+
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload of job %s: %w", key, err)
+	}
+
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$set": bson.M{
+				"kind":     kind,
+				"payload":  bson.Raw(raw),
+				"status":   types.JobPending,
+				"attempts": 0,
+				"run_at":   runAt,
+			},
+			"$unset":       bson.M{"last_error": "", "done_at": ""},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to schedule job %s: %w", key, err)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Cancel removes the jobs that haven't run yet whose key starts with
+// prefix.
+func (q *Queue) Cancel(ctx context.Context, prefix string) error {
+	collection, _ := db.GetCollection(jobsCollection)
+
+	_, err := collection.DeleteMany(ctx, bson.M{
+		"key":    bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+		"status": bson.M{"$in": []types.ScheduledJobStatus{types.JobPending, types.JobRunning}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cancel jobs %s: %w", prefix, err)
+	}
+
+	return nil
+}
+
+// claim takes the next due job and leases it to this worker.
+func (q *Queue) claim(ctx context.Context) (*types.ScheduledJob, error) {
+	collection, _ := db.GetCollection(jobsCollection)
+
+	now := time.Now()
+	filter := bson.M{
+		"status": bson.M{"$in": []types.ScheduledJobStatus{types.JobPending, types.JobRunning}},
+		"run_at": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": types.JobRunning, "run_at": now.Add(jobLease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	var job types.ScheduledJob
+	err := collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "run_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	return &job, nil
+}
+
+func (q *Queue) runJob(ctx context.Context, job types.ScheduledJob) error {
+	collection, _ := db.GetCollection(jobsCollection)
+
+	var runErr error
+	handler, ok := q.handler(job.Kind)
+	if ok {
+		runErr = q.call(ctx, handler, job)
+	} else {
+		runErr = fmt.Errorf("no handler for jobs of kind %s", job.Kind)
+	}
+
+	now := time.Now()
+	var update bson.M
+	switch {
+	case runErr == nil:
+		update = bson.M{
+			"$set":   bson.M{"status": types.JobDone, "done_at": now},
+			"$unset": bson.M{"last_error": ""},
+		}
+	case job.Attempts >= q.maxAttempts:
+		sentry.SentryHandler(runErr)
+		update = bson.M{"$set": bson.M{"status": types.JobFailed, "last_error": runErr.Error()}}
+	default:
+		update = bson.M{"$set": bson.M{
+			"status":     types.JobPending,
+			"last_error": runErr.Error(),
+			"run_at":     now.Add(retryDelay),
+		}}
+	}
+
+	// a job rescheduled while it ran keeps its new time
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": job.ID, "status": types.JobRunning, "run_at": job.RunAt},
+		update,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.Key, err)
+	}
+
+	return nil
+}
+
+// call runs the handler, turning a panic into an error so the job is
+// retried instead of stopping the worker.
+func (q *Queue) call(ctx context.Context, handler Handler, job types.ScheduledJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %s panicked: %v", job.Key, r)
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+// Process runs every job that is due.
+func (q *Queue) Process(ctx context.Context) error {
+	for {
+		job, err := q.claim(ctx)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+
+		if err := q.runJob(ctx, *job); err != nil {
+			return err
+		}
+	}
+}
+
+// Start runs the worker until the context is cancelled. It checks for due
+// jobs every minute and right after a job is scheduled.
+func (q *Queue) Start(ctx context.Context) {
+	Triggered(ctx, "scheduled-jobs", time.Minute, q.wake, q.Process)
+}
@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"henar-backend/sentry"
+)
+
+// Job is a unit of background work. Returned errors are reported and the job
+// keeps running on its next tick.
+type Job func(ctx context.Context) error
+
+// Every runs the job in the background once per interval until the context
+// is cancelled. The first run happens after the first interval.
+func Every(ctx context.Context, name string, interval time.Duration, job Job) {
+	Triggered(ctx, name, interval, nil, job)
+}
+
+// Triggered is like Every but also runs the job whenever a value is received
+// from trigger, for work that should start as soon as it is queued.
+func Triggered(ctx context.Context, name string, interval time.Duration, trigger <-chan struct{}, job Job) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run(ctx, name, job)
+			case <-trigger:
+				run(ctx, name, job)
+			}
+		}
+	}()
+}
+
+func run(ctx context.Context, name string, job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("scheduler: job %s panicked: %v", name, r)
+		}
+	}()
+
+	if err := job(ctx); err != nil {
+		sentry.SentryHandler(err)
+		log.Printf("scheduler: job %s failed: %s", name, err)
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
 	"henar-backend/db"
+	"henar-backend/events"
+	"henar-backend/internal/email"
+	"henar-backend/internal/push"
+	"henar-backend/internal/scheduler"
+	"henar-backend/notifications"
 	"henar-backend/routes"
 	"henar-backend/static"
 	"log"
@@ -52,9 +58,26 @@ func main() {
 
 	db.InitDb()
 
+	err = db.RunMigrations(context.Background(),
+		notifications.InboxMigration,
+		notifications.ReadAtMigration,
+	)
+	if err != nil {
+		log.Fatalf("db.RunMigrations: %s", err)
+	}
+
 	static.Init()
 
-	app := fiber.New()
+	static.StartSweeper(context.Background())
+
+	mailer, err := email.Init()
+	if err != nil {
+		log.Fatalf("email.Init: %s", err)
+	}
+
+	app := fiber.New(fiber.Config{
+		BodyLimit: static.MaxBodySize,
+	})
 
 	app.Use(logger.New())
 
@@ -69,5 +92,20 @@ func main() {
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
 	}))
 
-	routes.Setup(app)
+	outbox := email.NewOutbox(mailer)
+	outbox.Start(context.Background())
+
+	emailClient := email.NewClient(outbox)
+	notifications.Init(emailClient)
+	notifications.InitPush(push.Init())
+	notifications.StartDigests(context.Background())
+
+	jobs := scheduler.NewQueue()
+	err = events.InitReminders(jobs)
+	if err != nil {
+		log.Fatalf("events.InitReminders: %s", err)
+	}
+	jobs.Start(context.Background())
+
+	routes.Setup(app, emailClient)
 }
@@ -0,0 +1,92 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/types"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultAggregationHours = 24
+
+// aggregationWindow is how long after the latest similar notification a new
+// one is still merged into it, read from NOTIFICATIONS_AGGREGATION_HOURS.
+func aggregationWindow() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("NOTIFICATIONS_AGGREGATION_HOURS"))
+	if err != nil || hours < 0 {
+		hours = defaultAggregationHours
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+func bodyActor(body types.NotificationBody) []types.NotificationActor {
+	if body.PersonID.IsZero() {
+		return nil
+	}
+
+	return []types.NotificationActor{{
+		PersonID:       body.PersonID,
+		PersonFullName: body.PersonFullName,
+		Avatar:         body.Avatar,
+	}}
+}
+
+// aggregate merges the actors of a recent unread notification of the same
+// type and target into the new one. It returns the ID of the notification
+// that the new one replaces, or the nil ID when there is none.
+func aggregate(ctx context.Context, notification *types.Notification) (primitive.ObjectID, error) {
+	window := aggregationWindow()
+	if !notification.Type.IsAggregated() || window == 0 {
+		return primitive.NilObjectID, nil
+	}
+
+	collection, _ := db.GetCollection("notifications")
+
+	filter := bson.M{
+		"user_id":         notification.User,
+		"type":            notification.Type,
+		"body.project_id": notification.Body.ProjectID,
+		"status":          types.New,
+		"archived_at":     bson.M{"$exists": false},
+		"created_at":      bson.M{"$gte": notification.CreatedAt.Add(-window)},
+	}
+
+	var previous types.Notification
+	err := collection.FindOne(ctx, filter,
+		options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}}),
+	).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("failed to find similar notification: %w", err)
+	}
+
+	previousActors := previous.Actors
+	if len(previousActors) == 0 {
+		previousActors = bodyActor(previous.Body)
+	}
+
+	// the newest actor comes first, everyone is listed once
+	seen := map[primitive.ObjectID]bool{}
+	actors := []types.NotificationActor{}
+	for _, actor := range append(notification.Actors, previousActors...) {
+		if seen[actor.PersonID] {
+			continue
+		}
+		seen[actor.PersonID] = true
+		actors = append(actors, actor)
+	}
+	notification.Actors = actors
+
+	return previous.ID, nil
+}
@@ -2,67 +2,150 @@ package notifications
 
 import (
 	"context"
-	"fmt"
 	"henar-backend/db"
 	"henar-backend/sentry"
 	"henar-backend/types"
 	"henar-backend/utils"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func GetNotifications(c *fiber.Ctx) error {
-	userId := c.Locals("user_id")
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
 
-	if userId == nil {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+// sessionUserId returns the signed in user's ID. When ok is false the
+// response has already been written.
+func sessionUserId(c *fiber.Ctx) (primitive.ObjectID, bool) {
+	userId, _ := c.Locals("user_id").(string)
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		c.Status(http.StatusUnauthorized).JSON(fiber.Map{
 			"message": "not authorized",
 		})
+		return primitive.NilObjectID, false
 	}
-	objId, _ := primitive.ObjectIDFromHex(userId.(string))
 
-	usersCollection, _ := db.GetCollection("users")
-	usersFilter := bson.M{"_id": objId}
-	var user types.User
-	err := usersCollection.FindOne(context.TODO(), usersFilter).Decode(&user)
-	if err != nil {
-		sentry.SentryHandler(err)
+	return objId, true
+}
 
-		if err == mongo.ErrNoDocuments {
-			return c.Status(http.StatusNotFound).SendString("User not found")
-		}
-		return c.Status(http.StatusInternalServerError).SendString("Error retrieving user: " + err.Error())
+// @Summary Get notifications
+// @Description Returns the current user's notifications, newest first, one page at a time. Archived notifications are only listed with archived=true.
+// @Tags notifications
+// @Produce json
+// @Param before query string false "Cursor from the previous page"
+// @Param limit query int false "Page size, default 20, at most 100"
+// @Param status query string false "Filter by status: new or read"
+// @Param archived query bool false "List archived notifications instead"
+// @Param language query string false "Language of the notification texts (en, ru, hy), defaults to the user's language"
+// @Success 200 {object} types.NotificationsPage
+// @Failure 400 {string} string "Invalid cursor or limit"
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications [get]
+func GetNotifications(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
 	}
 
-	notificationsCollection, _ := db.GetCollection("notifications")
-	notificationsFilter := bson.M{"_id": bson.M{"$in": user.Notifications}}
+	limit := defaultPageSize
+	if c.Query("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return c.Status(http.StatusBadRequest).SendString("Invalid limit")
+		}
+	}
 
-	if len(user.Notifications) == 0 {
-		c.Status(http.StatusOK).JSON(nil)
-		return nil
+	filter := bson.M{"user_id": userId, "archived_at": bson.M{"$exists": c.QueryBool("archived")}}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if before := c.Query("before"); before != "" {
+		cursorId, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).SendString("Invalid cursor")
+		}
+		filter["_id"] = bson.M{"$lt": cursorId}
 	}
 
-	cursor, err := notificationsCollection.Find(context.TODO(), notificationsFilter)
+	// ObjectIDs grow with creation time, so they order newest first and
+	// make a stable cursor
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(limit) + 1)
+
+	collection, _ := db.GetCollection("notifications")
+	cursor, err := collection.Find(context.TODO(), filter, findOptions)
 	if err != nil {
 		sentry.SentryHandler(err)
-		return c.Status(http.StatusInternalServerError).SendString("Error finding notifications" + err.Error())
+		return c.Status(http.StatusInternalServerError).SendString("Error finding notifications")
 	}
 
-	var results []types.NotificationResponse
+	results := []types.NotificationResponse{}
 	if err := cursor.All(context.TODO(), &results); err != nil {
 		sentry.SentryHandler(err)
 		return c.Status(http.StatusInternalServerError).SendString("Error querying database: " + err.Error())
 	}
-	c.Status(http.StatusOK).JSON(results)
 
-	return nil
+	page := types.NotificationsPage{Notifications: results}
+	if len(results) > limit {
+		page.Notifications = results[:limit]
+		page.NextCursor = results[limit-1].ID.Hex()
+	}
+	renderTexts(context.TODO(), page.Notifications, requestLanguage(c, userId))
+
+	return c.Status(http.StatusOK).JSON(page)
 }
 
+// @Summary Get unread notifications count
+// @Description Counts the current user's notifications that are not read yet.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} map[string]int
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/unread-count [get]
+func GetUnreadCount(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	count, err := UnreadCount(context.TODO(), userId)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error counting notifications")
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"count": count})
+}
+
+// @Summary Read notifications
+// @Description Marks the given notifications of the current user as read. IDs of other users' notifications are ignored.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param body body types.NotificationAcceptiongRequestBody true "Notification IDs"
+// @Success 200 {object} map[string]int
+// @Failure 400 {string} string "Error parsing request body"
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications [post]
 func ReadNotifications(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
 	var body types.NotificationAcceptiongRequestBody
 	err := c.BodyParser(&body)
 	if err != nil {
@@ -76,10 +159,34 @@ func ReadNotifications(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).SendString("Error parsing IDs")
 	}
 
+	return markRead(c, userId, bson.M{"_id": bson.M{"$in": notificationsIds}})
+}
+
+// @Summary Read all notifications
+// @Description Marks every notification of the current user as read.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} map[string]int
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/read-all [post]
+func ReadAllNotifications(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	return markRead(c, userId, bson.M{})
+}
+
+func markRead(c *fiber.Ctx, userId primitive.ObjectID, filter bson.M) error {
 	collection, _ := db.GetCollection("notifications")
-	filter := bson.M{"_id": bson.M{"$in": notificationsIds}}
+
+	filter["user_id"] = userId
+	filter["status"] = types.New
 	update := bson.M{"$set": bson.M{
-		"status": types.Read,
+		"status":  types.Read,
+		"read_at": time.Now(),
 	}}
 
 	updatedNotifications, err := collection.UpdateMany(context.TODO(), filter, update)
@@ -88,8 +195,88 @@ func ReadNotifications(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).SendString("Error updating notifications")
 	}
 
-	fmt.Println(updatedNotifications)
-	c.Status(http.StatusOK).JSON(updatedNotifications)
+	if updatedNotifications.ModifiedCount > 0 {
+		publishUnreadCount(userId)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"modified": updatedNotifications.ModifiedCount})
+}
+
+// @Summary Archive notification
+// @Description Hides a notification of the current user from the inbox and marks it as read.
+// @Tags notifications
+// @Produce json
+// @Param id path string true "Notification ID"
+// @Success 200 {string} string "Notification archived"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "Notification not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/{id}/archive [post]
+func ArchiveNotification(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	collection, _ := db.GetCollection("notifications")
+	result, err := collection.UpdateOne(context.TODO(),
+		bson.M{"_id": id, "user_id": userId},
+		bson.M{"$set": bson.M{"archived_at": time.Now(), "status": types.Read, "read_at": time.Now()}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error archiving notification")
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(http.StatusNotFound).SendString("Notification not found")
+	}
+
+	if result.ModifiedCount > 0 {
+		publishUnreadCount(userId)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Notification archived"})
+}
+
+// @Summary Delete notification
+// @Description Deletes a notification of the current user.
+// @Tags notifications
+// @Produce json
+// @Param id path string true "Notification ID"
+// @Success 200 {string} string "Notification deleted"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "Notification not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/{id} [delete]
+func DeleteNotification(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	collection, _ := db.GetCollection("notifications")
+	result, err := collection.DeleteOne(context.TODO(), bson.M{"_id": id, "user_id": userId})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error deleting notification")
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(http.StatusNotFound).SendString("Notification not found")
+	}
+
+	publishUnreadCount(userId)
 
-	return nil
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Notification deleted"})
 }
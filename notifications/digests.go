@@ -0,0 +1,179 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/scheduler"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// digestLimit caps the notifications listed in one digest email.
+const digestLimit = 50
+
+// SendDigests emails every user whose digest is due the notifications they
+// have not read since their last digest.
+func SendDigests(ctx context.Context) error {
+	usersCollection, _ := db.GetCollection("users")
+
+	now := time.Now()
+	var users []types.User
+	for _, frequency := range []types.DigestFrequency{types.DigestDaily, types.DigestWeekly} {
+		cursor, err := usersCollection.Find(ctx, bson.M{
+			"digest_frequency":  frequency,
+			"is_email_verified": true,
+			"$or": bson.A{
+				bson.M{"last_digest_at": bson.M{"$exists": false}},
+				bson.M{"last_digest_at": bson.M{"$lte": now.Add(-frequency.Period())}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to find %s digest users: %w", frequency, err)
+		}
+
+		var due []types.User
+		if err := cursor.All(ctx, &due); err != nil {
+			return fmt.Errorf("failed to decode %s digest users: %w", frequency, err)
+		}
+		users = append(users, due...)
+	}
+
+	for _, user := range users {
+		if err := sendDigest(ctx, user, now); err != nil {
+			sentry.SentryHandler(err)
+		}
+	}
+
+	return nil
+}
+
+func sendDigest(ctx context.Context, user types.User, now time.Time) error {
+	usersCollection, _ := db.GetCollection("users")
+	notificationsCollection, _ := db.GetCollection("notifications")
+
+	// moving last_digest_at first makes sure only one run sends this digest
+	claim := bson.M{"_id": user.ID, "last_digest_at": bson.M{"$exists": false}}
+	if user.LastDigestAt != nil {
+		claim = bson.M{"_id": user.ID, "last_digest_at": *user.LastDigestAt}
+	}
+	result, err := usersCollection.UpdateOne(ctx, claim, bson.M{"$set": bson.M{"last_digest_at": now}})
+	if err != nil {
+		return fmt.Errorf("failed to claim digest of %s: %w", user.ID.Hex(), err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	filter := bson.M{"user_id": user.ID, "status": types.New}
+	if user.LastDigestAt != nil {
+		filter["created_at"] = bson.M{"$gt": *user.LastDigestAt}
+	} else {
+		filter["created_at"] = bson.M{"$gt": now.Add(-user.DigestFrequency.Period())}
+	}
+
+	cursor, err := notificationsCollection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(digestLimit),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find digest notifications: %w", err)
+	}
+
+	var unread []types.Notification
+	if err := cursor.All(ctx, &unread); err != nil {
+		return fmt.Errorf("failed to decode digest notifications: %w", err)
+	}
+	if len(unread) == 0 || mailer == nil {
+		return nil
+	}
+
+	return mailer.SendDigestEmail(user, user.DigestFrequency, unread)
+}
+
+// StartDigests checks for due digests every hour.
+func StartDigests(ctx context.Context) {
+	scheduler.Every(ctx, "notification-digests", time.Hour, SendDigests)
+}
+
+// @Summary Get digest settings
+// @Description Returns how often the current user receives an email digest of unread notifications.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} types.DigestSettings
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/digest [get]
+func GetDigestSettings(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	return c.Status(http.StatusOK).JSON(digestSettings(*user))
+}
+
+// @Summary Update digest settings
+// @Description Sets how often the current user receives an email digest of unread notifications: off, daily or weekly.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param settings body types.DigestSettings true "Digest frequency"
+// @Success 200 {object} types.DigestSettings
+// @Failure 400 {string} string "Invalid frequency"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/digest [patch]
+func UpdateDigestSettings(c *fiber.Ctx) error {
+	var body types.DigestSettings
+	if err := c.BodyParser(&body); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+	}
+	if !body.Frequency.IsValid() {
+		return c.Status(http.StatusBadRequest).SendString("Invalid frequency: " + string(body.Frequency))
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	collection, _ := db.GetCollection("users")
+	_, err = collection.UpdateOne(context.TODO(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"digest_frequency": body.Frequency}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error updating digest settings")
+	}
+	user.DigestFrequency = body.Frequency
+
+	return c.Status(http.StatusOK).JSON(digestSettings(*user))
+}
+
+func digestSettings(user types.User) types.DigestSettings {
+	frequency := user.DigestFrequency
+	if frequency == "" {
+		frequency = types.DigestOff
+	}
+
+	return types.DigestSettings{
+		Frequency:    frequency,
+		LastDigestAt: user.LastDigestAt,
+	}
+}
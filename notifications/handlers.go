@@ -4,54 +4,124 @@ import (
 	"context"
 	"errors"
 	"henar-backend/db"
+	"henar-backend/internal/email"
 	"henar-backend/sentry"
 	"henar-backend/types"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// mailer delivers the email channel. Without it notifications are in-app
+// only.
+var mailer *email.Client
+
+// Init connects the notification dispatcher to the email subsystem.
+func Init(emailClient *email.Client) {
+	mailer = emailClient
+}
+
+// CreateNotification dispatches a notification to the user through the
+// channels they chose for its type.
 func CreateNotification(notificationType types.NotificationType, userId primitive.ObjectID, body types.NotificationBody) error {
 	usersCollection, _ := db.GetCollection("users")
-	notificationsCollection, _ := db.GetCollection("notifications")
 
-	result, err := notificationsCollection.InsertOne(context.TODO(), types.Notification{
+	filter := bson.M{"_id": userId}
+	var user types.User
+	err := usersCollection.FindOne(context.TODO(), filter).Decode(&user)
+	if err != nil {
+		sentry.SentryHandler(err)
+
+		return errors.New("failed to find user")
+	}
+
+	notification := types.Notification{
 		ID:        primitive.NewObjectID(),
 		CreatedAt: time.Now(),
 		Status:    types.New,
 		Type:      notificationType,
 		User:      userId,
 		Body:      body,
-	})
-	if err != nil {
-		sentry.SentryHandler(err)
+	}
 
-		return errors.New("failed to create notification")
+	channel := Preference(user, notificationType)
+
+	if channel.InApp() {
+		if err := storeNotification(user, &notification); err != nil {
+			return err
+		}
 	}
 
-	notificationId, ok := result.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return errors.New("failed to create notification id")
+	if channel.Email() {
+		sendNotificationEmail(user, notification)
 	}
 
-	filter := bson.M{"_id": userId}
-	var user types.User
-	err = usersCollection.FindOne(context.TODO(), filter).Decode(&user)
+	if channel.Push() {
+		response := []types.NotificationResponse{notificationResponse(notification)}
+		renderTexts(context.TODO(), response, email.Language(user.Language))
+		go sendPush(context.Background(), user, response[0])
+	}
+
+	return nil
+}
+
+// storeNotification saves the in-app notification, possibly merged with an
+// earlier one of the same kind, and streams it to the user's open pages.
+func storeNotification(user types.User, notification *types.Notification) error {
+	notificationsCollection, _ := db.GetCollection("notifications")
+
+	notification.Actors = bodyActor(notification.Body)
+	replaced, err := aggregate(context.TODO(), notification)
 	if err != nil {
+		// aggregation is cosmetic, store the notification on its own
 		sentry.SentryHandler(err)
-
-		return errors.New("failed to find user")
 	}
 
-	user.Notifications = append(user.Notifications, notificationId)
-	update := bson.M{"$set": user}
-	_, err = usersCollection.UpdateOne(context.TODO(), filter, update)
+	_, err = notificationsCollection.InsertOne(context.TODO(), *notification)
 	if err != nil {
 		sentry.SentryHandler(err)
 
-		return errors.New("failed to update user")
+		return errors.New("failed to create notification")
+	}
+
+	if !replaced.IsZero() {
+		_, err = notificationsCollection.DeleteOne(context.TODO(), bson.M{"_id": replaced})
+		if err != nil {
+			sentry.SentryHandler(err)
+		}
+		publish(notification.User, NotificationRemovedEvent, fiber.Map{"_id": replaced})
 	}
 
+	response := []types.NotificationResponse{notificationResponse(*notification)}
+	renderTexts(context.TODO(), response, email.Language(user.Language))
+	publish(notification.User, NotificationEvent, response[0])
+	publishUnreadCount(notification.User)
+
 	return nil
 }
+
+func notificationResponse(notification types.Notification) types.NotificationResponse {
+	return types.NotificationResponse{
+		ID:         notification.ID,
+		CreatedAt:  notification.CreatedAt,
+		Status:     notification.Status,
+		Type:       notification.Type,
+		Body:       notification.Body,
+		Actors:     notification.Actors,
+		ArchivedAt: notification.ArchivedAt,
+	}
+}
+
+// sendNotificationEmail queues the email copy of a notification. Failures
+// are only reported, the in-app notification stands on its own.
+func sendNotificationEmail(user types.User, notification types.Notification) {
+	if mailer == nil || user.IsEmailVerified == nil || !*user.IsEmailVerified {
+		return
+	}
+
+	if err := mailer.SendNotificationEmail(user, notification); err != nil {
+		sentry.SentryHandler(err)
+	}
+}
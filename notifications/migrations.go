@@ -0,0 +1,49 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/types"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// InboxMigration drops the notification ID lists kept on users, which are
+// replaced by querying notifications by user_id.
+var InboxMigration = db.Migration{
+	Name: "retire_user_notifications",
+	Up: func(ctx context.Context) error {
+		collection, _ := db.GetCollection("users")
+
+		_, err := collection.UpdateMany(ctx,
+			bson.M{"user_body.notifications": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"user_body.notifications": ""}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to drop notification lists: %w", err)
+		}
+
+		return nil
+	},
+}
+
+// ReadAtMigration stamps notifications read before read_at was recorded, so
+// the retention period applies to them as well.
+var ReadAtMigration = db.Migration{
+	Name: "notifications_read_at",
+	Up: func(ctx context.Context) error {
+		collection, _ := db.GetCollection("notifications")
+
+		_, err := collection.UpdateMany(ctx,
+			bson.M{"status": types.Read, "read_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"read_at": time.Now()}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to stamp read notifications: %w", err)
+		}
+
+		return nil
+	},
+}
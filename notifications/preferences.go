@@ -0,0 +1,156 @@
+package notifications
+
+import (
+	"context"
+	"henar-backend/db"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// allChannels is the default for notifications users act on.
+var allChannels = types.Channels(types.InAppChannel, types.EmailChannel, types.PushChannel)
+
+// DefaultPreferences are used for types a user has not configured.
+var DefaultPreferences = types.NotificationPreferences{
+	types.ApproveApplicant:        allChannels,
+	types.ContactsRequested:       allChannels,
+	types.ContactsRequestApproved: allChannels,
+	types.ProjectRequest:          allChannels,
+	types.ProjectApproved:         allChannels,
+	types.ProjetcDeclined:         allChannels,
+	types.NewComment:              types.Channels(types.InAppChannel, types.PushChannel),
+	types.EventWaitlistPromoted:   allChannels,
+	types.EventReminder:           allChannels,
+	types.EventCancelled:          allChannels,
+}
+
+// Preference is the channel the user receives notifications of this type on.
+func Preference(user types.User, notificationType types.NotificationType) types.NotificationChannel {
+	if channel, ok := user.NotificationPreferences[notificationType]; ok && channel.IsValid() {
+		return channel
+	}
+	if channel, ok := DefaultPreferences[notificationType]; ok {
+		return channel
+	}
+
+	return types.InAppChannel
+}
+
+// Preferences returns the user's channel for every notification type.
+func Preferences(user types.User) types.NotificationPreferences {
+	preferences := types.NotificationPreferences{}
+	for _, notificationType := range types.NotificationTypes {
+		preferences[notificationType] = Preference(user, notificationType)
+	}
+
+	return preferences
+}
+
+// @Summary Get notification preferences
+// @Description Returns the channels (in_app, email, push, comma separated, or none) the current user receives each notification type on.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} types.NotificationPreferences
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/preferences [get]
+func GetPreferences(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	return c.Status(http.StatusOK).JSON(Preferences(*user))
+}
+
+// @Summary Update notification preferences
+// @Description Sets the channels for the given notification types: in_app, email and push combined with commas, e.g. "in_app,push", or none. Types left out keep their channels.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param preferences body types.NotificationPreferences true "Channel per notification type"
+// @Success 200 {object} types.NotificationPreferences
+// @Failure 400 {string} string "Invalid notification type or channel"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/preferences [patch]
+func UpdatePreferences(c *fiber.Ctx) error {
+	var body types.NotificationPreferences
+	if err := c.BodyParser(&body); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+	}
+
+	set := bson.M{}
+	for notificationType, channel := range body {
+		if !notificationType.IsValid() {
+			return c.Status(http.StatusBadRequest).SendString("Invalid notification type: " + string(notificationType))
+		}
+		if !channel.IsValid() {
+			return c.Status(http.StatusBadRequest).SendString("Invalid channel: " + string(channel))
+		}
+		set["notification_preferences."+string(notificationType)] = channel
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	if len(set) > 0 {
+		collection, _ := db.GetCollection("users")
+		_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, bson.M{"$set": set})
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusInternalServerError).SendString("Error updating preferences")
+		}
+	}
+
+	if user.NotificationPreferences == nil {
+		user.NotificationPreferences = types.NotificationPreferences{}
+	}
+	for notificationType, channel := range body {
+		user.NotificationPreferences[notificationType] = channel
+	}
+
+	return c.Status(http.StatusOK).JSON(Preferences(*user))
+}
+
+// currentUser loads the signed in user. When it returns a nil user the
+// response has already been written.
+func currentUser(c *fiber.Ctx) (*types.User, error) {
+	userId := c.Locals("user_id")
+	if userId == nil {
+		return nil, c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"message": "not authorized",
+		})
+	}
+	objId, _ := primitive.ObjectIDFromHex(userId.(string))
+
+	collection, _ := db.GetCollection("users")
+	var user types.User
+	err := collection.FindOne(context.TODO(), bson.M{"_id": objId}).Decode(&user)
+	if err != nil {
+		sentry.SentryHandler(err)
+		if err == mongo.ErrNoDocuments {
+			return nil, c.Status(http.StatusNotFound).SendString("User not found")
+		}
+		return nil, c.Status(http.StatusInternalServerError).SendString("Error retrieving user: " + err.Error())
+	}
+
+	return &user, nil
+}
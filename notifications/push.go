@@ -0,0 +1,222 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/push"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// pushTitle heads every push message, the text says what happened.
+const pushTitle = "HealthNet"
+
+// pusher delivers the push channel to the user's browsers. Without it push
+// is disabled.
+var pusher *push.Client
+
+// InitPush connects the notification dispatcher to a Web Push service.
+func InitPush(client *push.Client) {
+	pusher = client
+}
+
+// sendPush pushes a notification to every browser the user subscribed.
+// Subscriptions the push service forgot are removed.
+func sendPush(ctx context.Context, user types.User, notification types.NotificationResponse) {
+	if pusher == nil {
+		return
+	}
+
+	collection, _ := db.GetCollection("push_subscriptions")
+	cursor, err := collection.Find(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return
+	}
+
+	var subscriptions []types.PushSubscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		sentry.SentryHandler(err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	message := push.Message{
+		ID:    notification.ID.Hex(),
+		Title: pushTitle,
+		Body:  notification.Text,
+	}
+	if mailer != nil {
+		message.URL = mailer.NotificationLink(types.Notification{Type: notification.Type, Body: notification.Body})
+	}
+
+	for _, subscription := range subscriptions {
+		err := pusher.Send(ctx, push.Subscription{
+			Endpoint: subscription.Endpoint,
+			Keys:     push.Keys{P256dh: subscription.Keys.P256dh, Auth: subscription.Keys.Auth},
+		}, message)
+		if errors.Is(err, push.ErrGone) {
+			if _, err := collection.DeleteOne(ctx, bson.M{"_id": subscription.ID}); err != nil {
+				sentry.SentryHandler(err)
+			}
+			continue
+		}
+		if err != nil {
+			sentry.SentryHandler(fmt.Errorf("failed to push notification %s: %w", notification.ID.Hex(), err))
+		}
+	}
+}
+
+// @Summary Get VAPID public key
+// @Description Returns the application server key browsers subscribe to push notifications with.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 404 {string} string "Push notifications are disabled"
+// @Router /v1/notifications/push/vapid-public-key [get]
+func GetVAPIDPublicKey(c *fiber.Ctx) error {
+	if pusher == nil {
+		return c.Status(http.StatusNotFound).SendString("Push notifications are disabled")
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"publicKey": pusher.PublicKey})
+}
+
+// @Summary Get push subscriptions
+// @Description Lists the browsers the current user receives push notifications on.
+// @Tags notifications
+// @Produce json
+// @Success 200 {array} types.PushSubscription
+// @Failure 401 {string} string "not authorized"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/push/subscriptions [get]
+func GetPushSubscriptions(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	collection, _ := db.GetCollection("push_subscriptions")
+	cursor, err := collection.Find(context.TODO(), bson.M{"user_id": userId},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding subscriptions")
+	}
+
+	subscriptions := []types.PushSubscription{}
+	if err := cursor.All(context.TODO(), &subscriptions); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error querying database: " + err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(subscriptions)
+}
+
+// @Summary Subscribe to push notifications
+// @Description Registers the PushSubscription of the current browser. Subscribing an endpoint again refreshes its keys and moves it to the current user.
+// @Description The endpoint has to be an https URL of a public push service.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param subscription body types.PushSubscription true "PushSubscription from PushManager.subscribe"
+// @Success 201 {object} types.PushSubscription
+// @Failure 400 {string} string "Invalid subscription"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "Push notifications are disabled"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/push/subscriptions [post]
+func Subscribe(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	var body types.PushSubscription
+	if err := c.BodyParser(&body); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+	}
+
+	v := validator.New()
+	if err := v.Struct(body); err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid subscription: " + err.Error())
+	}
+	if pusher == nil {
+		return c.Status(http.StatusNotFound).SendString("Push notifications are disabled")
+	}
+	if err := pusher.ValidateEndpoint(context.TODO(), body.Endpoint); err != nil {
+		return c.Status(http.StatusBadRequest).SendString("Invalid subscription: " + err.Error())
+	}
+
+	// an endpoint belongs to a single browser, whoever signed in there last
+	// receives its notifications
+	collection, _ := db.GetCollection("push_subscriptions")
+	var subscription types.PushSubscription
+	err := collection.FindOneAndUpdate(context.TODO(),
+		bson.M{"endpoint": body.Endpoint},
+		bson.M{
+			"$set": bson.M{
+				"user_id":    userId,
+				"keys":       body.Keys,
+				"user_agent": c.Get(fiber.HeaderUserAgent),
+			},
+			"$setOnInsert": bson.M{"created_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&subscription)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error saving subscription")
+	}
+
+	return c.Status(http.StatusCreated).JSON(subscription)
+}
+
+// @Summary Unsubscribe from push notifications
+// @Description Removes a browser's push subscription of the current user.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param body body types.PushUnsubscribeRequestBody true "Subscription endpoint"
+// @Success 200 {string} string "Subscription removed"
+// @Failure 400 {string} string "Error parsing request body"
+// @Failure 401 {string} string "not authorized"
+// @Failure 404 {string} string "Subscription not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/notifications/push/subscriptions [delete]
+func Unsubscribe(c *fiber.Ctx) error {
+	userId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	var body types.PushUnsubscribeRequestBody
+	if err := c.BodyParser(&body); err != nil || body.Endpoint == "" {
+		return c.Status(http.StatusBadRequest).SendString("Error parsing request body")
+	}
+
+	collection, _ := db.GetCollection("push_subscriptions")
+	result, err := collection.DeleteOne(context.TODO(), bson.M{"endpoint": body.Endpoint, "user_id": userId})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error removing subscription")
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(http.StatusNotFound).SendString("Subscription not found")
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Subscription removed"})
+}
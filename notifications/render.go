@@ -0,0 +1,160 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/email"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// requestLanguage is the language notifications are rendered in for this
+// request: the language query parameter, then the user's own setting, then
+// the Accept-Language header.
+func requestLanguage(c *fiber.Ctx, userId primitive.ObjectID) string {
+	if language := c.Query("language"); language != "" {
+		return email.Language(language)
+	}
+
+	collection, _ := db.GetCollection("users")
+	var user types.User
+	err := collection.FindOne(context.TODO(), bson.M{"_id": userId},
+		options.FindOne().SetProjection(bson.M{"user_body.language": 1}),
+	).Decode(&user)
+	if err == nil && user.Language != "" {
+		return email.Language(user.Language)
+	}
+
+	accepted, _, _ := strings.Cut(c.Get(fiber.HeaderAcceptLanguage), ",")
+	return email.Language(accepted)
+}
+
+// resolveNames looks up the current names of the people and the titles of
+// the projects and events the notifications mention, in the given language.
+func resolveNames(ctx context.Context, notifications []types.NotificationResponse, language string) (map[primitive.ObjectID]string, map[string]string, map[string]string, error) {
+	personIds := []primitive.ObjectID{}
+	projectSlugs := []string{}
+	eventSlugs := []string{}
+	for _, notification := range notifications {
+		if !notification.Body.PersonID.IsZero() {
+			personIds = append(personIds, notification.Body.PersonID)
+		}
+		for _, actor := range notification.Actors {
+			personIds = append(personIds, actor.PersonID)
+		}
+		if notification.Body.ProjectID != "" {
+			projectSlugs = append(projectSlugs, notification.Body.ProjectID)
+		}
+		if notification.Body.EventID != "" {
+			eventSlugs = append(eventSlugs, notification.Body.EventID)
+		}
+	}
+
+	names := map[primitive.ObjectID]string{}
+	if len(personIds) > 0 {
+		usersCollection, _ := db.GetCollection("users")
+		cursor, err := usersCollection.Find(ctx, bson.M{"_id": bson.M{"$in": personIds}},
+			options.Find().SetProjection(bson.M{"user_body.first_name": 1, "user_body.last_name": 1}),
+		)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to find notification people: %w", err)
+		}
+
+		var users []types.User
+		if err := cursor.All(ctx, &users); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decode notification people: %w", err)
+		}
+		for _, user := range users {
+			if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+				names[user.ID] = name
+			}
+		}
+	}
+
+	titles := map[string]string{}
+	if len(projectSlugs) > 0 {
+		projectsCollection, _ := db.GetCollection("projects")
+		cursor, err := projectsCollection.Find(ctx, bson.M{"slug": bson.M{"$in": projectSlugs}},
+			options.Find().SetProjection(bson.M{"slug": 1, "title": 1}),
+		)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to find notification projects: %w", err)
+		}
+
+		var projects []types.Project
+		if err := cursor.All(ctx, &projects); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decode notification projects: %w", err)
+		}
+		for _, project := range projects {
+			if project.Slug != nil {
+				titles[*project.Slug] = project.Title.Localized(language)
+			}
+		}
+	}
+
+	eventTitles := map[string]string{}
+	if len(eventSlugs) > 0 {
+		eventsCollection, _ := db.GetCollection("events")
+		cursor, err := eventsCollection.Find(ctx, bson.M{"slug": bson.M{"$in": eventSlugs}},
+			options.Find().SetProjection(bson.M{"slug": 1, "title": 1}),
+		)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to find notification events: %w", err)
+		}
+
+		var events []types.Event
+		if err := cursor.All(ctx, &events); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decode notification events: %w", err)
+		}
+		for _, event := range events {
+			eventTitles[event.Slug] = event.Title.Localized(language)
+		}
+	}
+
+	return names, titles, eventTitles, nil
+}
+
+// renderTexts fills in the Text of every notification. Names and titles
+// that can no longer be resolved fall back to the ones stored in the body.
+func renderTexts(ctx context.Context, notifications []types.NotificationResponse, language string) {
+	names, titles, eventTitles, err := resolveNames(ctx, notifications, language)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
+	for i, notification := range notifications {
+		resolved := types.Notification{
+			Type: notification.Type,
+			Body: notification.Body,
+		}
+		if name, ok := names[notification.Body.PersonID]; ok {
+			resolved.Body.PersonFullName = name
+		}
+		if title, ok := titles[notification.Body.ProjectID]; ok {
+			resolved.Body.ProjectTitle = title
+		}
+		if title, ok := eventTitles[notification.Body.EventID]; ok {
+			resolved.Body.EventTitle = title
+		}
+		for _, actor := range notification.Actors {
+			if name, ok := names[actor.PersonID]; ok {
+				actor.PersonFullName = name
+			}
+			resolved.Actors = append(resolved.Actors, actor)
+		}
+
+		text, err := email.NotificationText(language, resolved)
+		if err != nil {
+			sentry.SentryHandler(err)
+			continue
+		}
+		notifications[i].Text = text
+	}
+}
@@ -0,0 +1,142 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/pubsub"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	NotificationEvent = "notification"
+	UnreadCountEvent  = "unread_count"
+	// sent when an aggregated notification replaces an older one
+	NotificationRemovedEvent = "notification_removed"
+
+	streamHeartbeat = 25 * time.Second
+)
+
+// hub carries new notifications to the open streams of their users.
+var hub pubsub.Broker = pubsub.NewMemory()
+
+// UseBroker replaces the in-process hub, e.g. with a broker shared by
+// several API instances.
+func UseBroker(broker pubsub.Broker) {
+	hub = broker
+}
+
+func userTopic(userId primitive.ObjectID) string {
+	return "notifications:" + userId.Hex()
+}
+
+func publish(userId primitive.ObjectID, event string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return
+	}
+
+	hub.Publish(pubsub.Message{Topic: userTopic(userId), Event: event, Data: payload})
+}
+
+// UnreadCount counts the user's notifications that are not read yet.
+func UnreadCount(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	collection, _ := db.GetCollection("notifications")
+
+	count, err := collection.CountDocuments(ctx, bson.M{"user_id": userId, "status": types.New})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+
+	return count, nil
+}
+
+// publishUnreadCount tells the user's open streams their new unread count.
+func publishUnreadCount(userId primitive.ObjectID) {
+	count, err := UnreadCount(context.TODO(), userId)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return
+	}
+
+	publish(userId, UnreadCountEvent, fiber.Map{"count": count})
+}
+
+// @Summary Stream notifications
+// @Description Server-Sent Events stream of the current user's notifications. A "notification" event carries each new notification and an "unread_count" event every change of the unread count, starting with the current count. A "notification_removed" event names a notification merged into a newer one.
+// @Tags notifications
+// @Produce text/event-stream
+// @Success 200 {string} string "Event stream"
+// @Failure 401 {string} string "not authorized"
+// @Router /v1/notifications/stream [get]
+func StreamNotifications(c *fiber.Ctx) error {
+	objId, ok := sessionUserId(c)
+	if !ok {
+		return nil
+	}
+
+	count, err := UnreadCount(context.TODO(), objId)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error counting notifications")
+	}
+	initial, _ := json.Marshal(fiber.Map{"count": count})
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	messages, unsubscribe := hub.Subscribe(userTopic(objId))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		if writeEvent(w, UnreadCountEvent, initial) != nil {
+			return
+		}
+
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				if writeEvent(w, msg.Event, msg.Data) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				// comments keep proxies from closing an idle stream and
+				// reveal clients that went away
+				if _, err := w.WriteString(": ping\n\n"); err != nil {
+					return
+				}
+				if w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+func writeEvent(w *bufio.Writer, event string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
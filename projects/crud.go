@@ -7,6 +7,7 @@ import (
 	"henar-backend/db"
 	"henar-backend/notifications"
 	"henar-backend/sentry"
+	"henar-backend/static"
 	"henar-backend/types"
 	"henar-backend/utils"
 	"net/http"
@@ -395,6 +396,12 @@ func CreateProject(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).SendString("Error updating user: " + err.Error())
 	}
 
+	coversRef := types.FileReference{Collection: "projects", Document: createdProject.ID, Field: "covers"}
+	err = static.SetReferences(coversRef, createdProject.Covers)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// Set the response headers and write the response body
 	return c.Status(http.StatusCreated).JSON(createdProject)
 }
@@ -502,6 +509,12 @@ func UpdateProject(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).SendString("Error retrieving updated project: " + err.Error())
 	}
 
+	coversRef := types.FileReference{Collection: "projects", Document: objId, Field: "covers"}
+	err = static.SetReferences(coversRef, updatedProject.Covers)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// Set the response headers and write the response body
 	return c.Status(http.StatusOK).JSON(updatedProject)
 }
@@ -589,6 +602,11 @@ func DeleteProject(store *session.Store) func(c *fiber.Ctx) error {
 			return c.Status(http.StatusNotFound).SendString("Project not found")
 		}
 
+		err = static.ReleaseReferences("projects", projectObjId)
+		if err != nil {
+			sentry.SentryHandler(err)
+		}
+
 		return c.SendString("Project deleted successfully")
 	}
 }
@@ -596,10 +614,12 @@ func DeleteProject(store *session.Store) func(c *fiber.Ctx) error {
 // RespondToProject responds to a project by adding the current user as an applicant.
 // @Summary Respond to a project
 // @Description Adds the current user as an applicant to the specified project.
+// @Description An attachment such as a CV can be sent along as the URL of a private file the user uploaded, only the project creator can read it.
 // @Tags projects
 // @Accept json
 // @Produce json
 // @Param id path string true "Project ID"
+// @Param attachment query string false "URL of an uploaded attachment"
 // @Success 200 {object} types.Project
 // @Failure 400 {string} string "Invalid ID or project ID"
 // @Failure 500 {string} string "Error connecting to database or updating/retrieving project"
@@ -640,6 +660,28 @@ func RespondToProject(c *fiber.Ctx) error {
 	}
 	project.Applicants[requesterObjId] = true
 
+	attachment := c.Query("attachment")
+	attachmentRef := types.FileReference{
+		Collection: "projects",
+		Document:   projectObjId,
+		Field:      static.ApplicationAttachmentField + requesterObjId.Hex(),
+	}
+	allowed, err := static.CanReference(attachmentRef, requesterObjId, []string{attachment})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).SendString("Error checking attachment: " + err.Error())
+	}
+	if !allowed {
+		return c.Status(http.StatusBadRequest).SendString("The attachment must be a file you uploaded")
+	}
+	if project.ApplicationAttachments == nil {
+		project.ApplicationAttachments = make(map[primitive.ObjectID]string)
+	}
+	if attachment != "" {
+		project.ApplicationAttachments[requesterObjId] = attachment
+	} else {
+		delete(project.ApplicationAttachments, requesterObjId)
+	}
+
 	// Update the project document in MongoDB
 	filter = bson.M{"_id": projectObjId}
 	update := bson.M{"$set": project}
@@ -649,6 +691,11 @@ func RespondToProject(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).SendString("Error updating project: " + err.Error())
 	}
 
+	err = static.SetReferences(attachmentRef, []string{attachment})
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// update approver
 	usersCollection, _ := db.GetCollection("users")
 
@@ -748,13 +795,26 @@ func CancelProjectApplication(c *fiber.Ctx) error {
 
 	// Update the project document in MongoDB
 	filter = bson.M{"_id": projectObjId}
-	update := bson.M{"$set": bson.M{"applicants": project.Applicants}}
+	update := bson.M{
+		"$set":   bson.M{"applicants": project.Applicants},
+		"$unset": bson.M{"application_attachments." + requesterObjId.Hex(): ""},
+	}
 	_, err = collection.UpdateOne(context.TODO(), filter, update)
 	if err != nil {
 		sentry.SentryHandler(err)
 		return c.Status(http.StatusInternalServerError).SendString("Error updating project: " + err.Error())
 	}
 
+	attachmentRef := types.FileReference{
+		Collection: "projects",
+		Document:   projectObjId,
+		Field:      static.ApplicationAttachmentField + requesterObjId.Hex(),
+	}
+	err = static.SetReferences(attachmentRef, nil)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// Retrieve the updated project from MongoDB
 	filter = bson.M{"_id": projectObjId}
 	var updatedProject types.Project
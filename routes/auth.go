@@ -20,110 +20,114 @@ import (
 )
 
 // TODO: update create user and sing up to db method
-func SignUp(c *fiber.Ctx) error {
-	var uc types.User
-	err := c.BodyParser(&uc)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
-	}
-	if uc.Password == nil {
-		return c.Status(http.StatusBadRequest).SendString("Password is required")
-	}
+func SignUp(mailer *email.Client) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var uc types.User
+		err := c.BodyParser(&uc)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+		}
+		if uc.Password == nil {
+			return c.Status(http.StatusBadRequest).SendString("Password is required")
+		}
 
-	// Validate the required fields
-	v := validator.New()
-	err = v.Struct(uc)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(http.StatusBadRequest).SendString("error validating user: " + err.Error())
-	}
+		// Validate the required fields
+		v := validator.New()
+		err = v.Struct(uc)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusBadRequest).SendString("error validating user: " + err.Error())
+		}
 
-	// Hash the password
-	Password, err := bcrypt.GenerateFromPassword(
-		[]byte(*uc.Password),
-		bcrypt.DefaultCost,
-	)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return fmt.Errorf("Error hashing password: %w", err)
-	}
-	passwordString := string(Password)
-	specialist := types.Specialist
-	IsEmailVerified := false
-	user := types.User{
-		IsActivated:     false,
-		IsEmailVerified: &IsEmailVerified,
-		UserCredentials: types.UserCredentials{
-			Email:    uc.Email,
-			Password: &passwordString,
-		},
-		UserBody: types.UserBody{
-			Role: &specialist,
-			ContactsRequest: types.ContactsRequest{
-				IncomingContactRequests:   make(map[primitive.ObjectID]string),
-				OutgoingContactRequests:   make(map[primitive.ObjectID]string),
-				ConfirmedContactsRequests: make(map[primitive.ObjectID]string),
-				BlockedUsers:              make(map[primitive.ObjectID]string),
+		// Hash the password
+		Password, err := bcrypt.GenerateFromPassword(
+			[]byte(*uc.Password),
+			bcrypt.DefaultCost,
+		)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return fmt.Errorf("Error hashing password: %w", err)
+		}
+		passwordString := string(Password)
+		specialist := types.Specialist
+		IsEmailVerified := false
+		user := types.User{
+			IsActivated:     false,
+			IsEmailVerified: &IsEmailVerified,
+			UserCredentials: types.UserCredentials{
+				Email:    uc.Email,
+				Password: &passwordString,
 			},
-			UserProjects: types.UserProjects{
-				ProjectsApplications:  make(map[primitive.ObjectID]primitive.ObjectID),
-				ConfirmedApplications: make(map[primitive.ObjectID]primitive.ObjectID),
-				RejectedApplicants:    make(map[primitive.ObjectID]primitive.ObjectID),
-				CreatedProjects:       make(map[primitive.ObjectID]bool),
+			UserBody: types.UserBody{
+				Role:      &specialist,
+				FirstName: uc.FirstName,
+				LastName:  uc.LastName,
+				Language:  uc.Language,
+				ContactsRequest: types.ContactsRequest{
+					IncomingContactRequests:   make(map[primitive.ObjectID]string),
+					OutgoingContactRequests:   make(map[primitive.ObjectID]string),
+					ConfirmedContactsRequests: make(map[primitive.ObjectID]string),
+					BlockedUsers:              make(map[primitive.ObjectID]string),
+				},
+				UserProjects: types.UserProjects{
+					ProjectsApplications:  make(map[primitive.ObjectID]primitive.ObjectID),
+					ConfirmedApplications: make(map[primitive.ObjectID]primitive.ObjectID),
+					RejectedApplicants:    make(map[primitive.ObjectID]primitive.ObjectID),
+					CreatedProjects:       make(map[primitive.ObjectID]bool),
+				},
 			},
-		},
-	}
-	v.Struct(user)
+		}
+		v.Struct(user)
+
+		// Check if the email address is already in use
+		collection, _ := db.GetCollection("users")
+		filter := bson.M{"user_credentials.email": user.UserCredentials.Email}
+		var existingUser types.User
+		err = collection.FindOne(context.TODO(), filter).Decode(&existingUser)
+		if err == nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusBadRequest).SendString("Email address already in use")
+		}
 
-	// Check if the email address is already in use
-	collection, _ := db.GetCollection("users")
-	filter := bson.M{"user_credentials.email": user.UserCredentials.Email}
-	var existingUser types.User
-	err = collection.FindOne(context.TODO(), filter).Decode(&existingUser)
-	if err == nil {
-		sentry.SentryHandler(err)
-		return c.Status(http.StatusBadRequest).SendString("Email address already in use")
-	}
+		// Insert user document into MongoDB
+		result, err := collection.InsertOne(context.TODO(), user)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return fmt.Errorf("Error creating user: %w", err)
+		}
 
-	// Insert user document into MongoDB
-	result, err := collection.InsertOne(context.TODO(), user)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return fmt.Errorf("Error creating user: ", err)
-	}
+		// Get the ID of the inserted user document
+		objId := result.InsertedID.(primitive.ObjectID)
 
-	// Get the ID of the inserted user document
-	objId := result.InsertedID.(primitive.ObjectID)
+		// Retrieve the updated user from MongoDB
+		filter = bson.M{"_id": objId}
+		var createdUser types.User
+		err = collection.FindOne(context.TODO(), filter).Decode(&createdUser)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return fmt.Errorf("Error retrieving created user: %w", err)
+		}
 
-	// Retrieve the updated user from MongoDB
-	filter = bson.M{"_id": objId}
-	var createdUser types.User
-	err = collection.FindOne(context.TODO(), filter).Decode(&createdUser)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return fmt.Errorf("Error retrieving created user: ", err)
-	}
+		// Create verification data for the new user and insert it into db
+		verificationData, err := CreateVerificationData(createdUser.ID, createdUser.Email, "mail_confirmation")
+		if err != nil {
+			sentry.SentryHandler(err)
+			return err
+		}
 
-	// Create verification data for the new user and insert it into db
-	verificationData, err := CreateVerificationData(createdUser.ID, createdUser.Email, "mail_confirmation")
-	if err != nil {
-		sentry.SentryHandler(err)
-		return err
-	}
+		// Queue email for email verification, the user can request it again
+		// if it could not be queued
+		err = mailer.SendConfirmationEmail(createdUser, verificationData)
+		if err != nil {
+			sentry.SentryHandler(err)
+		}
 
-	// Send email for email verification
-	mailjetClient := email.Init()
-	err = mailjetClient.SendConfirmationEmail(verificationData)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return err
+		// Set the response headers and write the response body
+		return c.Status(http.StatusCreated).JSON(fiber.Map{
+			"userId": createdUser.ID,
+		})
 	}
-
-	// Set the response headers and write the response body
-	return c.Status(http.StatusCreated).JSON(fiber.Map{
-		"userId": createdUser.ID,
-	})
 }
 
 func SignIn(c *fiber.Ctx) error {
@@ -247,34 +251,35 @@ func Check(c *fiber.Ctx) error {
 // @Failure 400 {object} map[string]string "Bad Request"
 // @Failure 500 {object} map[string]string "Internal Server Error"
 // @Router /auth/forgot-password [post]
-func ForgotPassword(c *fiber.Ctx) error {
-	var requestBody types.ForgotPassword
-	err := c.BodyParser(&requestBody)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
-	}
+func ForgotPassword(mailer *email.Client) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var requestBody types.ForgotPassword
+		err := c.BodyParser(&requestBody)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+		}
 
-	user, err := GetUserByEmail(requestBody.Email, c)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return err
-	}
+		user, err := GetUserByEmail(requestBody.Email, c)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return err
+		}
 
-	verificationData, err := CreateVerificationData(user.ID, user.Email, "pass_reset")
-	if err != nil {
-		sentry.SentryHandler(err)
-		return err
-	}
+		verificationData, err := CreateVerificationData(user.ID, user.Email, "pass_reset")
+		if err != nil {
+			sentry.SentryHandler(err)
+			return err
+		}
 
-	mailjetClient := email.Init()
-	err = mailjetClient.SendPasswordResetEmail(verificationData)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
-	}
+		err = mailer.SendPasswordResetEmail(user, verificationData)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
 
-	return c.JSON(fiber.Map{"message": "Password reset email sent"})
+		return c.JSON(fiber.Map{"message": "Password reset email sent"})
+	}
 }
 
 // @Summary Reset password
@@ -415,48 +420,55 @@ func VerifyEmail(c *fiber.Ctx) error {
 // @Failure 400 {string} string "email not found"
 // @Failure 500 {string} string "Error resending email"
 // @Router /auth/verify-email/{token, email} [post]
-func ResendVerificationEmail(c *fiber.Ctx) error {
-	var payload types.ResendVerificationEmail
-	err := c.BodyParser(&payload)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
-	}
+func ResendVerificationEmail(mailer *email.Client) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var payload types.ResendVerificationEmail
+		err := c.BodyParser(&payload)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusBadRequest).SendString("Error parsing request body: " + err.Error())
+		}
 
-	v := validator.New()
-	err = v.Struct(payload)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return c.Status(http.StatusBadRequest).SendString("error validating: " + err.Error())
-	}
+		v := validator.New()
+		err = v.Struct(payload)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(http.StatusBadRequest).SendString("error validating: " + err.Error())
+		}
 
-	token := payload.Token
-	userEmail := payload.Email
+		token := payload.Token
+		userEmail := payload.Email
+
+		if userEmail == "" {
+			// if no email provided then searching it by token
+			verificationData, err := FindVerificationDataByCode(token)
+			if err != nil {
+				sentry.SentryHandler(err)
+				return err
+			}
+			userEmail = verificationData.Email
+		}
 
-	if userEmail == "" {
-		// if no email provided then searching it by token
-		verificationData, err := FindVerificationDataByCode(token)
+		// Generate new code and update verification data
+		updatedVerificationData, err := UpdateVerificationData(userEmail, c)
 		if err != nil {
 			sentry.SentryHandler(err)
 			return err
 		}
-		userEmail = verificationData.Email
-	}
 
-	// Generate new code and update verification data
-	updatedVerificationData, err := UpdateVerificationData(userEmail, c)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return err
-	}
+		user, err := GetUserByID(updatedVerificationData.User, c)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return err
+		}
 
-	// Send email for email verification
-	mailjetClient := email.Init()
-	err = mailjetClient.SendConfirmationEmail(updatedVerificationData)
-	if err != nil {
-		sentry.SentryHandler(err)
-		return err
-	}
+		// Send email for email verification
+		err = mailer.SendConfirmationEmail(user, updatedVerificationData)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return err
+		}
 
-	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "email resend successfully", "email": updatedVerificationData.Email})
+		return c.Status(http.StatusOK).JSON(fiber.Map{"message": "email resend successfully", "email": updatedVerificationData.Email})
+	}
 }
@@ -0,0 +1,355 @@
+package routes
+
+import (
+	"context"
+	"encoding/base64"
+	"henar-backend/db"
+	"henar-backend/internal/email"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"henar-backend/utils"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// @Summary List email templates
+// @Description Lists the names of the transactional email templates. Admins only.
+// @Tags emails
+// @Produce json
+// @Success 200 {array} string
+// @Failure 403 {string} string "Permission or ownership error"
+// @Router /v1/emails/templates [get]
+func GetEmailTemplates(c *fiber.Ctx) error {
+	if c.Locals("userRole") != "admin" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	return c.JSON(email.Templates())
+}
+
+// @Summary Preview email template
+// @Description Renders an email template with sample data. Admins only.
+// @Tags emails
+// @Produce html
+// @Param template path string true "Template name"
+// @Param language query string false "Language code (en, ru, hy), default 'en'"
+// @Param format query string false "html (default), text or json"
+// @Success 200 {string} string "Rendered email"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "Template not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/emails/preview/{template} [get]
+func PreviewEmail(mailer *email.Client) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if c.Locals("userRole") != "admin" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Permission or ownership error",
+			})
+		}
+
+		name := c.Params("template")
+		if !slices.Contains(email.Templates(), name) {
+			return c.Status(fiber.StatusNotFound).SendString("Template not found")
+		}
+
+		rendered, err := email.Render(name, c.Query("language"), mailer.SampleData(name))
+		if err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		switch c.Query("format") {
+		case "text":
+			c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+			return c.SendString(rendered.Text)
+		case "json":
+			return c.JSON(rendered)
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.SendString(rendered.HTML)
+	}
+}
+
+// @Summary Get email outbox
+// @Description Lists queued and sent emails, newest first. Admins only.
+// @Tags emails
+// @Produce json
+// @Param status query string false "Filter by status: pending, sending, sent, failed or suppressed"
+// @Param limit query int false "Limit"
+// @Param offset query int false "Offset"
+// @Success 200 {array} types.OutboxEmail
+// @Failure 400 {string} string "Invalid pagination parameters"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/emails/outbox [get]
+func GetOutbox(c *fiber.Ctx) error {
+	if c.Locals("userRole") != "admin" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	collection, _ := db.GetCollection("email_outbox")
+
+	findOptions, err := utils.GetPaginationOptions(c)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid pagination parameters")
+	}
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := collection.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error finding emails")
+	}
+
+	results := []types.OutboxEmail{}
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error finding emails")
+	}
+
+	return c.JSON(results)
+}
+
+// @Summary Get email outbox stats
+// @Description Counts outbox emails by status. Admins only.
+// @Tags emails
+// @Produce json
+// @Success 200 {object} map[string]int
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/emails/outbox/stats [get]
+func GetOutboxStats(c *fiber.Ctx) error {
+	if c.Locals("userRole") != "admin" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	collection, _ := db.GetCollection("email_outbox")
+
+	cursor, err := collection.Aggregate(context.TODO(), mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error counting emails")
+	}
+
+	var groups []struct {
+		Status types.OutboxStatus `bson:"_id"`
+		Count  int                `bson:"count"`
+	}
+	if err = cursor.All(context.TODO(), &groups); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error counting emails")
+	}
+
+	stats := map[types.OutboxStatus]int{
+		types.OutboxPending:    0,
+		types.OutboxSending:    0,
+		types.OutboxSent:       0,
+		types.OutboxFailed:     0,
+		types.OutboxSuppressed: 0,
+	}
+	for _, group := range groups {
+		stats[group.Status] = group.Count
+	}
+
+	return c.JSON(stats)
+}
+
+// @Summary Retry email
+// @Description Queues an email that ran out of delivery attempts again. Admins only.
+// @Tags emails
+// @Produce json
+// @Param id path string true "Outbox email ID"
+// @Success 200 {string} string "Email queued"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "Failed email not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/emails/outbox/{id}/retry [post]
+func RetryOutboxEmail(mailer *email.Client) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if c.Locals("userRole") != "admin" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Permission or ownership error",
+			})
+		}
+
+		id, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid ID")
+		}
+
+		err = mailer.RetryEmail(id)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).SendString("Failed email not found")
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		return c.JSON(fiber.Map{"message": "Email queued"})
+	}
+}
+
+// @Summary Email provider webhook
+// @Description Receives delivery events (bounce, spam, blocked) from the email provider and records them on the affected users.
+// @Description Requests are authenticated either by an X-Webhook-Signature header with the hex HMAC-SHA256 of the body or by basic auth, both keyed with EMAIL_WEBHOOK_SECRET.
+// @Tags emails
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Events recorded"
+// @Failure 400 {string} string "Invalid payload"
+// @Failure 401 {string} string "Invalid signature"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/emails/webhook [post]
+func EmailWebhook(c *fiber.Ctx) error {
+	secret := os.Getenv("EMAIL_WEBHOOK_SECRET")
+	body := c.Body()
+
+	if !email.VerifySignature(body, c.Get("X-Webhook-Signature"), secret) &&
+		!email.VerifyBasicAuth(basicAuthPassword(c), secret) {
+		return c.Status(fiber.StatusUnauthorized).SendString("Invalid signature")
+	}
+
+	events, err := email.ParseMailjetEvents(body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid payload: " + err.Error())
+	}
+
+	for _, event := range events {
+		if err := email.RecordEvent(context.TODO(), event); err != nil {
+			sentry.SentryHandler(err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Error recording events")
+		}
+	}
+
+	return c.JSON(fiber.Map{"message": "Events recorded", "count": len(events)})
+}
+
+func basicAuthPassword(c *fiber.Ctx) string {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(auth, "Basic ") {
+		return ""
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return ""
+	}
+
+	_, password, _ := strings.Cut(string(decoded), ":")
+	return password
+}
+
+// @Summary Get undeliverable users
+// @Description Lists users whose email address bounced, was blocked or reported spam, most recent first. Admins only.
+// @Tags emails
+// @Produce json
+// @Param status query string false "Filter by status: soft_bounced, hard_bounced, spam_complaint or blocked"
+// @Param limit query int false "Limit"
+// @Param offset query int false "Offset"
+// @Success 200 {array} types.UndeliverableUser
+// @Failure 400 {string} string "Invalid pagination parameters"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/emails/undeliverable [get]
+func GetUndeliverableUsers(c *fiber.Ctx) error {
+	if c.Locals("userRole") != "admin" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	collection, _ := db.GetCollection("users")
+
+	findOptions, err := utils.GetPaginationOptions(c)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid pagination parameters")
+	}
+	findOptions.SetSort(bson.D{{Key: "email_deliverability.updated_at", Value: -1}})
+	findOptions.SetProjection(bson.M{
+		"email":                "$user_credentials.email",
+		"first_name":           "$user_body.first_name",
+		"last_name":            "$user_body.last_name",
+		"email_deliverability": 1,
+	})
+
+	filter := bson.M{"email_deliverability.status": bson.M{"$exists": true}}
+	if status := c.Query("status"); status != "" {
+		filter["email_deliverability.status"] = status
+	}
+
+	cursor, err := collection.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error finding users")
+	}
+
+	results := []types.UndeliverableUser{}
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error finding users")
+	}
+
+	return c.JSON(results)
+}
+
+// @Summary Clear email status
+// @Description Forgets the delivery problems recorded for a user so emails are sent to them again. Admins only.
+// @Tags emails
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {string} string "Email status cleared"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/emails/undeliverable/{id} [delete]
+func ClearEmailStatus(c *fiber.Ctx) error {
+	if c.Locals("userRole") != "admin" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	collection, _ := db.GetCollection("users")
+	result, err := collection.UpdateOne(context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$unset": bson.M{"email_deliverability": ""}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error clearing email status")
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusNotFound).SendString("User not found")
+	}
+
+	return c.JSON(fiber.Map{"message": "Email status cleared"})
+}
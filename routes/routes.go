@@ -2,6 +2,7 @@ package routes
 
 import (
 	"henar-backend/events"
+	"henar-backend/internal/email"
 	"henar-backend/locations"
 	"henar-backend/notifications"
 	"henar-backend/projects"
@@ -24,20 +25,20 @@ var (
 	USER_ROLE string = "user_role"
 )
 
-func Setup(app *fiber.App) {
+func Setup(app *fiber.App, mailer *email.Client) {
 	store = session.New(session.Config{
 		CookieHTTPOnly: true,
 		Expiration:     time.Hour * 3000,
 	})
 
 	authGroup := app.Group("/v1/auth")
-	authGroup.Post("/signup", SignUp)
+	authGroup.Post("/signup", SignUp(mailer))
 	authGroup.Post("/signin", SignIn)
 	authGroup.Get("/signout", SignOut)
 	authGroup.Get("/check", AuthorMiddleware, Check)
-	authGroup.Post("/forgot-password", CreateRateLimiter(), ForgotPassword)
+	authGroup.Post("/forgot-password", CreateRateLimiter(), ForgotPassword(mailer))
 	authGroup.Get("/verify-email", VerifyEmail)
-	authGroup.Post("/resend-verification-email", ResendVerificationEmail)
+	authGroup.Post("/resend-verification-email", ResendVerificationEmail(mailer))
 	authGroup.Post("/reset-password", ResetPassword)
 
 	// Locations routes
@@ -54,12 +55,31 @@ func Setup(app *fiber.App) {
 	// Events routes
 	eventsGroup := app.Group("/v1/events", AdminMiddleware, AuthorMiddleware)
 	eventsGroup.Get("", events.GetEvents)
+	eventsGroup.Get("/feed.ics", events.GetEventsFeed)
+	eventsGroup.Get("/calendar/:token", events.GetPersonalFeed)
 	eventsGroup.Get("/:slug", events.GetEvent)
 
 	eventsGroupSecured := app.Group("/v1/events", SessionMiddleware, AdminMiddleware, AuthorMiddleware)
 	eventsGroupSecured.Post("", events.CreateEvent)
 	eventsGroupSecured.Patch("/:id", events.UpdateEvent)
 	eventsGroupSecured.Delete("/:id", events.DeleteEvent)
+	eventsGroupSecured.Post("/:id/rsvp", events.RSVP)
+	eventsGroupSecured.Delete("/:id/rsvp", events.CancelRSVP)
+	eventsGroupSecured.Get("/:id/attendees", events.GetAttendees)
+	eventsGroupSecured.Get("/:id/ticket", events.GetTicket)
+	eventsGroupSecured.Post("/:id/check-in", events.CheckIn)
+	eventsGroupSecured.Get("/:id/attendance", events.GetAttendance)
+	eventsGroupSecured.Get("/:id/certificate", events.GetCertificate)
+	eventsGroupSecured.Patch("/:id/occurrences/:date", events.UpdateOccurrence)
+	eventsGroupSecured.Delete("/:id/occurrences/:date", events.CancelOccurrence)
+
+	myEventsGroupSecured := app.Group("/v1/my-events", SessionMiddleware, AdminMiddleware, AuthorMiddleware)
+	myEventsGroupSecured.Get("", events.GetMyEvents)
+	myEventsGroupSecured.Get("/calendar", events.GetPersonalFeedLink)
+	myEventsGroupSecured.Post("/calendar/reset", events.ResetPersonalFeedLink)
+
+	certificatesGroup := app.Group("/v1/certificates")
+	certificatesGroup.Get("/:code", events.VerifyCertificate)
 
 	// Statistics routes
 	statisticsGroup := app.Group("/v1/statistics")
@@ -146,12 +166,41 @@ func Setup(app *fiber.App) {
 	usersGroupAdmin.Get("/make-admin/:id", users.AddUserToAdmins)
 	usersGroupAdmin.Get("/remove-admin/:id", users.RemoveUserFromAdmins)
 
-	staticGroup := app.Group("/v1/files")
-	staticGroup.Post("/upload", static.UploadFile)
+	app.Post("/v1/emails/webhook", EmailWebhook)
+
+	emailsGroupAdmin := app.Group("/v1/emails", SessionMiddleware, AdminMiddleware)
+	emailsGroupAdmin.Get("/templates", GetEmailTemplates)
+	emailsGroupAdmin.Get("/preview/:template", PreviewEmail(mailer))
+	emailsGroupAdmin.Get("/outbox", GetOutbox)
+	emailsGroupAdmin.Get("/outbox/stats", GetOutboxStats)
+	emailsGroupAdmin.Post("/outbox/:id/retry", RetryOutboxEmail(mailer))
+	emailsGroupAdmin.Get("/undeliverable", GetUndeliverableUsers)
+	emailsGroupAdmin.Delete("/undeliverable/:id", ClearEmailStatus)
+
+	staticGroupSecured := app.Group("/v1/files", SessionMiddleware, AdminMiddleware, AuthorMiddleware)
+	staticGroupSecured.Post("/upload", static.UploadFile)
+	staticGroupSecured.Get("/usage", static.GetFileUsage)
+	staticGroupSecured.Get("/quarantine", static.GetQuarantinedFiles)
+	staticGroupSecured.Get("/:id/url", static.GetFileURL)
+	staticGroupSecured.Get("/:id/download", static.DownloadFile)
+	staticGroupSecured.Delete("/:id", static.DeleteFile)
 
 	notificationsGroupSecured := app.Group("/v1/notifications", SessionMiddleware, AdminMiddleware, AuthorMiddleware)
 	notificationsGroupSecured.Get("", notifications.GetNotifications)
 	notificationsGroupSecured.Post("", notifications.ReadNotifications)
+	notificationsGroupSecured.Get("/unread-count", notifications.GetUnreadCount)
+	notificationsGroupSecured.Post("/read-all", notifications.ReadAllNotifications)
+	notificationsGroupSecured.Get("/preferences", notifications.GetPreferences)
+	notificationsGroupSecured.Patch("/preferences", notifications.UpdatePreferences)
+	notificationsGroupSecured.Get("/stream", notifications.StreamNotifications)
+	notificationsGroupSecured.Get("/digest", notifications.GetDigestSettings)
+	notificationsGroupSecured.Patch("/digest", notifications.UpdateDigestSettings)
+	notificationsGroupSecured.Get("/push/vapid-public-key", notifications.GetVAPIDPublicKey)
+	notificationsGroupSecured.Get("/push/subscriptions", notifications.GetPushSubscriptions)
+	notificationsGroupSecured.Post("/push/subscriptions", notifications.Subscribe)
+	notificationsGroupSecured.Delete("/push/subscriptions", notifications.Unsubscribe)
+	notificationsGroupSecured.Post("/:id/archive", notifications.ArchiveNotification)
+	notificationsGroupSecured.Delete("/:id", notifications.DeleteNotification)
 
 	app.Listen(":8080")
 }
@@ -0,0 +1,137 @@
+package static
+
+import (
+	"context"
+	"errors"
+	"henar-backend/db"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ApplicationAttachmentField prefixes the reference field of a project
+// application attachment, followed by the applicant's ID.
+const ApplicationAttachmentField = "application_attachments."
+
+// CanAccessFile reports whether a user may download a file: admins always
+// can, quarantined files are admin only, owners can read their uploads and
+// everyone else needs access to a document that references the file.
+func CanAccessFile(file types.File, userId primitive.ObjectID, isAdmin bool) (bool, error) {
+	if isAdmin {
+		return true, nil
+	}
+
+	if file.Status == types.FileQuarantined {
+		return false, nil
+	}
+
+	if !file.Private {
+		return true, nil
+	}
+
+	for _, owner := range file.Owners {
+		if owner == userId {
+			return true, nil
+		}
+	}
+
+	for _, ref := range file.References {
+		allowed, err := canAccessDocument(ref, userId)
+		if err != nil {
+			return false, err
+		}
+		if allowed {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func canAccessDocument(ref types.FileReference, userId primitive.ObjectID) (bool, error) {
+	collection, _ := db.GetCollection(ref.Collection)
+	filter := bson.M{"_id": ref.Document}
+
+	switch ref.Collection {
+	case "users":
+		if ref.Document == userId {
+			return true, nil
+		}
+
+		var user types.User
+		err := collection.FindOne(context.TODO(), filter).Decode(&user)
+		if err != nil {
+			return notFoundIsDenied(err)
+		}
+
+		// same rule as the contacts of a profile
+		return user.ConfirmedContactsRequests[userId] != "" ||
+			user.ConfirmedApplications[userId] != primitive.NilObjectID, nil
+
+	case "projects":
+		var project types.Project
+		err := collection.FindOne(context.TODO(), filter).Decode(&project)
+		if err != nil {
+			return notFoundIsDenied(err)
+		}
+
+		// what applicants send along is for the project creator only
+		if strings.HasPrefix(ref.Field, ApplicationAttachmentField) {
+			return project.CreatedBy == userId, nil
+		}
+
+		return project.CreatedBy == userId || project.SuccessfulApplicants[userId], nil
+
+	case "events":
+		var event types.Event
+		err := collection.FindOne(context.TODO(), filter).Decode(&event)
+		if err != nil {
+			return notFoundIsDenied(err)
+		}
+
+		return event.CreatedBy == userId, nil
+	}
+
+	return false, nil
+}
+
+// CanReference reports whether the document of ref may point to the files
+// behind urls: each of them has to be uploaded by the user the document
+// belongs to or be referenced by the field already. Otherwise anyone could
+// attach a private file of someone else and read it through the document.
+func CanReference(ref types.FileReference, ownerId primitive.ObjectID, urls []string) (bool, error) {
+	collection, _ := db.GetCollection("files")
+
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+
+		count, err := collection.CountDocuments(context.TODO(), bson.M{
+			"urls": url,
+			"$or":  bson.A{bson.M{"owners": ownerId}, bson.M{"references": ref}},
+		})
+		if err != nil {
+			sentry.SentryHandler(err)
+			return false, errors.New("failed to check file ownership")
+		}
+		if count == 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func notFoundIsDenied(err error) (bool, error) {
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	sentry.SentryHandler(err)
+	return false, errors.New("failed to check file access")
+}
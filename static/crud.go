@@ -1,70 +1,538 @@
 package static
 
 import (
-	"crypto/sha1"
-	"encoding/base64"
-	"fmt"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"henar-backend/db"
 	"henar-backend/sentry"
+	"henar-backend/types"
+	"henar-backend/utils"
+	"io"
 	"net/http"
 	"strings"
-	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // @Summary Upload file
-// @Description Upload a static file to Henar DigitalOcean failopoika's and get the uri
+// @Description Upload a static file to Henar DigitalOcean failopoika's and get the uri.
+// @Description The purpose selects the upload policy (size, allowed types detected from the content, image dimensions).
+// @Description Images are stripped of EXIF metadata and returned with resized and WebP variants.
 // @Tags files
 // @Accept multipart/form-data
 // @Produce json
-// @Success 200 {array} types.FileResponce
-// @Failure 400 {string} string "error reading file"
+// @Param file formData file true "File to upload"
+// @Param purpose formData string true "Upload purpose: avatar, project_cover, event_cover or attachment"
+// @Success 200 {object} types.FileResponce
+// @Failure 400 {string} string "error reading file or unknown purpose"
+// @Failure 401 {string} string "not authorized"
+// @Failure 403 {string} string "storage quota exceeded"
+// @Failure 413 {string} string "file is too large"
+// @Failure 415 {string} string "file type is not allowed"
+// @Failure 422 {string} string "invalid image or image dimensions"
+// @Failure 422 {string} string "file rejected by malware scan"
+// @Failure 429 {string} string "daily upload limit reached"
+// @Failure 503 {string} string "file scanning is unavailable"
+// @Failure 500 {string} string "error uploading file"
 // @Router /v1/files/upload [post]
 func UploadFile(c *fiber.Ctx) error {
+	var purpose types.UploadPurpose
+	err := purpose.UnmarshalText([]byte(c.FormValue("purpose")))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+
+	policy, err := GetUploadPolicy(purpose)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
 	file, err := c.FormFile("file")
 	if err != nil {
 		sentry.SentryHandler(err)
-		c.Status(http.StatusBadRequest).JSON(fiber.Map{
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"msg": "error reading file",
-			"err": err,
 		})
 	}
 
-	fmt.Println(file.Filename)
-
 	buffer, err := file.Open()
 	if err != nil {
 		sentry.SentryHandler(err)
-		c.Status(http.StatusBadRequest).JSON(fiber.Map{
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"msg": "error reading file",
-			"err": err,
 		})
 	}
 	defer buffer.Close()
 
-	fileNameSplit := strings.Split(file.Filename, ".")
-	fileExt := "." + fileNameSplit[len(fileNameSplit)-1]
-	fileNameMD5 := sha1.Sum([]byte(file.Filename + string(time.Now().String())))
-	fileNameHashString := base64.StdEncoding.EncodeToString(fileNameMD5[:])
-	fileNameFull := string(fileNameHashString) + fileExt
+	contentType, err := policy.Validate(buffer, file.Size)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
 
-	object := s3.PutObjectInput{
-		Bucket: aws.String("henar-static"),
-		Key:    aws.String(fileNameFull),
-		Body:   buffer,
-		ACL:    aws.String("public-read"),
+	userObjId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid ID")
 	}
-	_, err = s3Client.PutObject(&object)
+
+	// admins are counted but not limited by quotas
+	err = ReserveQuota(userObjId, file.Size, c.Locals("userRole") != "admin")
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+	// the reserved storage is given back unless the user ends up owning
+	// the file, in which case it is settled to the size actually stored
+	owned := false
+	defer func() {
+		if !owned {
+			releaseBytes(userObjId, file.Size)
+		}
+	}()
+
+	data, err := io.ReadAll(buffer)
 	if err != nil {
 		sentry.SentryHandler(err)
-		fmt.Println(err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"msg": "error reading file",
+		})
 	}
 
-	c.Status(http.StatusOK).JSON(fiber.Map{
-		"url": "https://henar-static.ams3.digitaloceanspaces.com/" + fileNameFull,
-	})
+	contentHash := sha256.Sum256(data)
+
+	record := types.File{
+		ID:          primitive.NewObjectID(),
+		UploadedBy:  userObjId,
+		Purpose:     purpose,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Hash:        hex.EncodeToString(contentHash[:]),
+		Private:     policy.Private,
+	}
+
+	quarantined, err := IsQuarantined(record.Hash)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+	if quarantined {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+			"msg": "file rejected by malware scan",
+		})
+	}
+
+	// the same content uploaded for the same purpose reuses the stored objects
+	existing, added, err := ClaimFile(record.Hash, purpose, userObjId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+	if existing != nil {
+		owned = added
+		if owned {
+			settleBytes(userObjId, file.Size, existing.Size)
+		}
+		return c.Status(http.StatusOK).JSON(fileResponse(*existing))
+	}
+
+	record, err = scanFile(record, data)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	if record.Status == types.FileQuarantined {
+		_, err = quarantineFile(record, data)
+		if err != nil {
+			sentry.SentryHandler(err)
+		}
+
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+			"msg": "file rejected by malware scan",
+		})
+	}
+
+	record, err = storeObjects(record, data, policy)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	record, owned, err = RegisterFile(record)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+	if owned {
+		settleBytes(userObjId, file.Size, record.Size)
+	}
+
+	return c.Status(http.StatusOK).JSON(fileResponse(record))
+}
+
+// storeObjects uploads the file and, for images, its processed variants.
+// The size of the record becomes the bytes of every stored object, which
+// is what counts towards the owners' quota.
+// Object keys are derived from the content hash so identical uploads map
+// to the same objects. Private files are not publicly readable and are
+// addressed through the download endpoint instead of the bucket URL.
+func storeObjects(record types.File, data []byte, policy UploadPolicy) (types.File, error) {
+	baseKey := string(record.Purpose) + "/" + record.Hash
+	record.Objects = make(map[string]string)
+
+	location := func(key, name string) string {
+		if record.Private {
+			return privateURL(record.ID, name)
+		}
+		return publicURL(key)
+	}
+
+	// PDFs are stored as they are, images are re-encoded without metadata
+	if !strings.HasPrefix(record.ContentType, "image/") {
+		key := baseKey + extensionFor(record.ContentType)
+		err := putObject(key, bytes.NewReader(data), record.ContentType, record.Private)
+		if err != nil {
+			return types.File{}, err
+		}
+
+		record.Keys = []string{key}
+		record.Objects["original"] = key
+		record.URL = location(key, "original")
+		record.URLs = []string{record.URL}
+		record.Size = int64(len(data))
+
+		return record, nil
+	}
+
+	processed, err := ProcessImage(data, record.ContentType, policy.Variants)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return types.File{}, &UploadError{Status: http.StatusUnprocessableEntity, Msg: "error processing image"}
+	}
+
+	record.Variants = make(map[string]string)
+	record.Size = 0
+	for _, image := range processed {
+		key := baseKey + "_" + image.Name + image.Ext
+		if image.Name == "original" {
+			key = baseKey + image.Ext
+		}
+
+		err = putObject(key, bytes.NewReader(image.Data), image.ContentType, record.Private)
+		if err != nil {
+			return types.File{}, err
+		}
+
+		url := location(key, image.Name)
+		if image.Name == "original" {
+			record.URL = url
+		} else {
+			record.Variants[image.Name] = url
+		}
+		record.Keys = append(record.Keys, key)
+		record.Objects[image.Name] = key
+		record.URLs = append(record.URLs, url)
+		record.Size += int64(len(image.Data))
+	}
+
+	return record, nil
+}
+
+func fileResponse(file types.File) types.FileResponce {
+	return types.FileResponce{
+		ID:       file.ID,
+		URL:      file.URL,
+		Variants: file.Variants,
+		Private:  file.Private,
+	}
+}
+
+// @Summary Get storage usage
+// @Description Returns the storage used and uploads made today by the current user. Admins can pass a user ID to see another user's usage.
+// @Tags files
+// @Produce json
+// @Param user query string false "User ID (admins only)"
+// @Success 200 {object} types.FileUsage
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/files/usage [get]
+func GetFileUsage(c *fiber.Ctx) error {
+	userId := c.Locals("user_id").(string)
+	isAdmin := c.Locals("userRole") == "admin"
+
+	if requested := c.Query("user"); requested != "" && requested != userId {
+		if !isAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Permission or ownership error",
+			})
+		}
+		userId = requested
+	}
+
+	userObjId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	usage, err := GetUsage(userObjId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+	usage.Unlimited = isAdmin && userId == c.Locals("user_id")
+
+	return c.Status(http.StatusOK).JSON(usage)
+}
+
+// @Summary Get file URL
+// @Description Returns a short-lived signed URL for a private file, or the public URL of a public one.
+// @Description Private files are only available to their owners, admins and users who can see a document referencing the file.
+// @Tags files
+// @Produce json
+// @Param id path string true "File ID"
+// @Param variant query string false "Image variant, e.g. thumbnail"
+// @Success 200 {object} types.SignedURL
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "File not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/files/{id}/url [get]
+func GetFileURL(c *fiber.Ctx) error {
+	signedURL, err := authorizedURL(c)
+	if err != nil {
+		return err
+	}
+	if signedURL == nil {
+		return nil
+	}
+
+	return c.Status(http.StatusOK).JSON(signedURL)
+}
 
-	return nil
+// @Summary Download file
+// @Description Redirects to a short-lived signed URL of the file after checking access. This is the URL stored in documents for private files.
+// @Tags files
+// @Param id path string true "File ID"
+// @Param variant query string false "Image variant, e.g. thumbnail"
+// @Success 302 {string} string "Redirect to the file"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "File not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/files/{id}/download [get]
+func DownloadFile(c *fiber.Ctx) error {
+	signedURL, err := authorizedURL(c)
+	if err != nil {
+		return err
+	}
+	if signedURL == nil {
+		return nil
+	}
+
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	return c.Redirect(signedURL.URL, http.StatusFound)
+}
+
+// authorizedURL resolves the requested file variant and checks that the
+// current user may read it. When it returns a nil URL the error response
+// has already been written.
+func authorizedURL(c *fiber.Ctx) (*types.SignedURL, error) {
+	collection, _ := db.GetCollection("files")
+
+	fileObjId, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return nil, c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	var file types.File
+	err = collection.FindOne(context.TODO(), bson.M{"_id": fileObjId}).Decode(&file)
+	if err != nil {
+		sentry.SentryHandler(err)
+		if err == mongo.ErrNoDocuments {
+			return nil, c.Status(http.StatusNotFound).SendString("File not found")
+		}
+		return nil, c.Status(http.StatusInternalServerError).SendString("Error getting file: " + err.Error())
+	}
+
+	userObjId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return nil, c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	allowed, err := CanAccessFile(file, userObjId, c.Locals("userRole") == "admin")
+	if err != nil {
+		return nil, c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+	if !allowed {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	variant := c.Query("variant", "original")
+	key, ok := file.Objects[variant]
+	if !ok {
+		return nil, c.Status(http.StatusNotFound).SendString("Variant not found")
+	}
+
+	if !file.Private {
+		return &types.SignedURL{URL: publicURL(key)}, nil
+	}
+
+	signedURL, err := presignObject(key)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return nil, c.Status(http.StatusInternalServerError).SendString("Error signing URL: " + err.Error())
+	}
+
+	return &signedURL, nil
+}
+
+// @Summary Get quarantined files
+// @Description Lists uploads flagged by the malware scanner, newest first. Admins only.
+// @Tags files
+// @Produce json
+// @Param limit query int false "Limit"
+// @Param offset query int false "Offset"
+// @Success 200 {array} types.File
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/files/quarantine [get]
+func GetQuarantinedFiles(c *fiber.Ctx) error {
+	if c.Locals("userRole") != "admin" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	collection, _ := db.GetCollection("files")
+
+	findOptions, err := utils.GetPaginationOptions(c)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid pagination parameters")
+	}
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := collection.Find(context.TODO(), bson.M{"status": types.FileQuarantined}, findOptions)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding files")
+	}
+
+	results := []types.File{}
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusInternalServerError).SendString("Error finding files")
+	}
+
+	return c.Status(http.StatusOK).JSON(results)
+}
+
+// @Summary Delete file
+// @Description Releases the caller's upload of a file. Identical uploads share stored objects, which are deleted with all variants once no upload and no document refers to them.
+// @Description Admins can delete any file that is not referenced by a document. Quarantined files can only be deleted by admins.
+// @Tags files
+// @Produce json
+// @Param id path string true "File ID"
+// @Success 200 {string} string "File deleted successfully or released"
+// @Failure 400 {string} string "Invalid ID"
+// @Failure 403 {string} string "Permission or ownership error"
+// @Failure 404 {string} string "File not found"
+// @Failure 409 {string} string "File is still in use"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /v1/files/{id} [delete]
+func DeleteFile(c *fiber.Ctx) error {
+	collection, _ := db.GetCollection("files")
+
+	fileObjId, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	var file types.File
+	err = collection.FindOne(context.TODO(), bson.M{"_id": fileObjId}).Decode(&file)
+	if err != nil {
+		sentry.SentryHandler(err)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).SendString("File not found")
+		}
+		return c.Status(http.StatusInternalServerError).SendString("Error getting file: " + err.Error())
+	}
+
+	userObjId, err := primitive.ObjectIDFromHex(c.Locals("user_id").(string))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid ID")
+	}
+
+	isAdmin := c.Locals("userRole") == "admin"
+	// quarantined files are kept for review, only an admin can remove them
+	if file.Status == types.FileQuarantined && !isAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	isOwner := false
+	for _, owner := range file.Owners {
+		if owner == userObjId {
+			isOwner = true
+		}
+	}
+
+	if isOwner && file.Status != types.FileQuarantined {
+		deleted, err := ReleaseFile(file, userObjId)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).SendString("Error deleting file: " + err.Error())
+		}
+		if !deleted {
+			return c.SendString("File released, it is still used elsewhere")
+		}
+
+		return c.SendString("File deleted successfully")
+	}
+
+	if !isAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Permission or ownership error",
+		})
+	}
+
+	if len(file.References) > 0 {
+		return c.Status(http.StatusConflict).SendString("File is still in use")
+	}
+
+	err = removeFile(file)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).SendString("Error deleting file: " + err.Error())
+	}
+
+	return c.SendString("File deleted successfully")
+}
+
+func uploadErrorResponse(c *fiber.Ctx, err error) error {
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return c.Status(uploadErr.Status).JSON(fiber.Map{
+			"msg": uploadErr.Msg,
+		})
+	}
+
+	sentry.SentryHandler(err)
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"msg": "error uploading file",
+	})
 }
@@ -2,17 +2,30 @@ package static
 
 import (
 	"fmt"
+	"henar-backend/internal/scanner"
 	"henar-backend/sentry"
+	"henar-backend/types"
+	"io"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var s3Client *s3.S3
+var (
+	s3Client    *s3.S3
+	fileScanner scanner.Scanner
+	scanEnabled bool
+)
 
 func Init() error {
+	fileScanner, scanEnabled = scanner.Init()
+
 	s3Config := &aws.Config{
 		Credentials:      credentials.NewStaticCredentials("DO009TG799ZCZG7WCBHU", "ok6N6/xDW2BsLas+HG4aMI5rBZOt6Krhr0djzGSAclg", ""),
 		Endpoint:         aws.String("https://ams3.digitaloceanspaces.com"),
@@ -29,3 +42,68 @@ func Init() error {
 
 	return nil
 }
+
+const (
+	bucket     = "henar-static"
+	publicHost = "https://henar-static.ams3.digitaloceanspaces.com/"
+)
+
+func putObject(key string, body io.ReadSeeker, contentType string, private bool) error {
+	acl := "public-read"
+	if private {
+		acl = "private"
+	}
+
+	_, err := s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ACL:         aws.String(acl),
+		ContentType: aws.String(contentType),
+	})
+
+	return err
+}
+
+func publicURL(key string) string {
+	return publicHost + key
+}
+
+// privateURL is the stable API path of a private file or one of its variants.
+// Documents store this path, the download endpoint exchanges it for a
+// short-lived signed URL after checking access.
+func privateURL(id primitive.ObjectID, name string) string {
+	url := "/v1/files/" + id.Hex() + "/download"
+	if name != "original" {
+		url += "?variant=" + name
+	}
+
+	return url
+}
+
+func signedURLTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("FILES_SIGNED_URL_TTL_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 15
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+func presignObject(key string) (types.SignedURL, error) {
+	ttl := signedURLTTL()
+	request, _ := s3Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+
+	url, err := request.Presign(ttl)
+	if err != nil {
+		return types.SignedURL{}, err
+	}
+
+	return types.SignedURL{
+		URL:       url,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
@@ -0,0 +1,156 @@
+package static
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// ImageVariant is a resized copy of an uploaded image. Images are scaled down
+// to fit MaxSize on their longest side and never scaled up.
+type ImageVariant struct {
+	Name    string
+	MaxSize int
+}
+
+var avatarVariants = []ImageVariant{
+	{Name: "thumbnail", MaxSize: 64},
+	{Name: "medium", MaxSize: 256},
+	{Name: "large", MaxSize: 512},
+}
+
+var coverVariants = []ImageVariant{
+	{Name: "thumbnail", MaxSize: 320},
+	{Name: "medium", MaxSize: 960},
+	{Name: "large", MaxSize: 1920},
+}
+
+// ProcessedImage is a single encoded output of the image pipeline.
+type ProcessedImage struct {
+	Name        string
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// ProcessImage decodes an uploaded image, applies its EXIF orientation and
+// re-encodes it, which drops EXIF (including GPS) and any other metadata.
+// The first result is the sanitized original, followed by every variant in
+// the source format and as WebP.
+func ProcessImage(data []byte, contentType string, variants []ImageVariant) ([]ProcessedImage, error) {
+	// the header is checked before anything is allocated for the pixels
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType == "image/jpeg" {
+		src = applyOrientation(src, jpegOrientation(data))
+	}
+
+	// PNG keeps transparency, everything else is served as JPEG
+	outputType := "image/jpeg"
+	if contentType == "image/png" {
+		outputType = "image/png"
+	}
+
+	original, err := encodeImage(src, outputType)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ProcessedImage{{
+		Name:        "original",
+		ContentType: outputType,
+		Ext:         extensionFor(outputType),
+		Data:        original,
+	}}
+
+	for _, variant := range variants {
+		resized := resizeImage(src, variant.MaxSize)
+
+		encoded, err := encodeImage(resized, outputType)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ProcessedImage{
+			Name:        variant.Name,
+			ContentType: outputType,
+			Ext:         extensionFor(outputType),
+			Data:        encoded,
+		})
+
+		encoded, err = encodeImage(resized, "image/webp")
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ProcessedImage{
+			Name:        variant.Name + "_webp",
+			ContentType: "image/webp",
+			Ext:         extensionFor("image/webp"),
+			Data:        encoded,
+		})
+	}
+
+	return results, nil
+}
+
+func resizeImage(src image.Image, maxSize int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w <= maxSize && h <= maxSize {
+		return src
+	}
+
+	if w >= h {
+		h = h * maxSize / w
+		w = maxSize
+	} else {
+		w = w * maxSize / h
+		h = maxSize
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	return dst
+}
+
+func encodeImage(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch contentType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
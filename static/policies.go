@@ -0,0 +1,203 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"henar-backend/types"
+	"image"
+	"io"
+	"net/http"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	MB = 1 << 20
+
+	// MaxBodySize is the request body limit the app must accept so that the
+	// largest upload policy plus multipart overhead fits in one request.
+	MaxBodySize = 10*MB + MB
+
+	// MaxPixels caps the size of a decoded image regardless of its
+	// dimensions, each pixel takes 4 bytes while it is processed.
+	MaxPixels = 16_000_000
+)
+
+// UploadPolicy describes what may be uploaded for a given purpose.
+// Dimension limits only apply to image types, a zero value means no limit.
+type UploadPolicy struct {
+	MaxSize      int64
+	AllowedTypes []string
+	MinWidth     int
+	MinHeight    int
+	MaxWidth     int
+	MaxHeight    int
+	Variants     []ImageVariant
+	// Private files are stored without public access and only served
+	// through signed URLs
+	Private bool
+}
+
+var imageTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+var uploadPolicies = map[types.UploadPurpose]UploadPolicy{
+	types.AvatarUpload: {
+		MaxSize:      2 * MB,
+		AllowedTypes: imageTypes,
+		MinWidth:     64,
+		MinHeight:    64,
+		MaxWidth:     4096,
+		MaxHeight:    4096,
+		Variants:     avatarVariants,
+	},
+	types.ProjectCoverUpload: {
+		MaxSize:      5 * MB,
+		AllowedTypes: imageTypes,
+		MinWidth:     320,
+		MinHeight:    180,
+		MaxWidth:     6000,
+		MaxHeight:    6000,
+		Variants:     coverVariants,
+	},
+	types.EventCoverUpload: {
+		MaxSize:      5 * MB,
+		AllowedTypes: imageTypes,
+		MinWidth:     320,
+		MinHeight:    180,
+		MaxWidth:     6000,
+		MaxHeight:    6000,
+		Variants:     coverVariants,
+	},
+	types.AttachmentUpload: {
+		MaxSize:      10 * MB,
+		AllowedTypes: append([]string{"application/pdf"}, imageTypes...),
+		MaxWidth:     6000,
+		MaxHeight:    6000,
+		Private:      true,
+	},
+}
+
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+// UploadError is returned when an upload violates its policy and carries the
+// HTTP status the client should receive.
+type UploadError struct {
+	Status int
+	Msg    string
+}
+
+func (e *UploadError) Error() string {
+	return e.Msg
+}
+
+func GetUploadPolicy(purpose types.UploadPurpose) (UploadPolicy, error) {
+	policy, ok := uploadPolicies[purpose]
+	if !ok {
+		return UploadPolicy{}, &UploadError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("unknown upload purpose: %q", purpose)}
+	}
+
+	return policy, nil
+}
+
+func (p UploadPolicy) allows(contentType string) bool {
+	for _, allowed := range p.AllowedTypes {
+		if allowed == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DetectContentType sniffs the MIME type from the first bytes of the file
+// and rewinds the reader so it can be read again from the start.
+func DetectContentType(file io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(head[:n]), nil
+}
+
+// Validate checks the size, sniffed type and image dimensions of the file
+// against the policy and returns the detected content type.
+func (p UploadPolicy) Validate(file io.ReadSeeker, size int64) (string, error) {
+	if size <= 0 {
+		return "", &UploadError{Status: http.StatusBadRequest, Msg: "file is empty"}
+	}
+
+	if size > p.MaxSize {
+		return "", &UploadError{
+			Status: http.StatusRequestEntityTooLarge,
+			Msg:    fmt.Sprintf("file is larger than %d bytes", p.MaxSize),
+		}
+	}
+
+	contentType, err := DetectContentType(file)
+	if err != nil {
+		return "", &UploadError{Status: http.StatusBadRequest, Msg: "error reading file"}
+	}
+
+	if !p.allows(contentType) {
+		return "", &UploadError{
+			Status: http.StatusUnsupportedMediaType,
+			Msg:    fmt.Sprintf("file type %s is not allowed", contentType),
+		}
+	}
+
+	if contentType == "application/pdf" {
+		return contentType, nil
+	}
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", &UploadError{Status: http.StatusUnprocessableEntity, Msg: "file is not a valid image"}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", &UploadError{Status: http.StatusBadRequest, Msg: "error reading file"}
+	}
+
+	if config.Width < p.MinWidth || config.Height < p.MinHeight {
+		return "", &UploadError{
+			Status: http.StatusUnprocessableEntity,
+			Msg:    fmt.Sprintf("image must be at least %dx%d pixels", p.MinWidth, p.MinHeight),
+		}
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return "", &UploadError{
+			Status: http.StatusUnprocessableEntity,
+			Msg:    fmt.Sprintf("image must have at most %d pixels", MaxPixels),
+		}
+	}
+
+	if (p.MaxWidth > 0 && config.Width > p.MaxWidth) ||
+		(p.MaxHeight > 0 && config.Height > p.MaxHeight) {
+		return "", &UploadError{
+			Status: http.StatusUnprocessableEntity,
+			Msg:    fmt.Sprintf("image must be at most %dx%d pixels", p.MaxWidth, p.MaxHeight),
+		}
+	}
+
+	return contentType, nil
+}
+
+func extensionFor(contentType string) string {
+	return extensions[contentType]
+}
@@ -0,0 +1,239 @@
+package static
+
+import (
+	"context"
+	"errors"
+	"henar-backend/db"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultQuotaBytes   = 200 * MB
+	defaultDailyUploads = 50
+)
+
+func quotaBytes() int64 {
+	quota, err := strconv.ParseInt(os.Getenv("FILES_QUOTA_BYTES"), 10, 64)
+	if err != nil || quota <= 0 {
+		return defaultQuotaBytes
+	}
+
+	return quota
+}
+
+func dailyUploads() int64 {
+	limit, err := strconv.ParseInt(os.Getenv("FILES_DAILY_UPLOADS"), 10, 64)
+	if err != nil || limit <= 0 {
+		return defaultDailyUploads
+	}
+
+	return limit
+}
+
+// Storage and uploads are counted per user so that a quota can be reserved
+// with a single guarded update instead of checking usage and uploading
+// afterwards. usageCollection holds one document per user with the bytes
+// of the files they own, uploadsCollection one per user and day.
+const (
+	usageCollection   = "file_usage"
+	uploadsCollection = "upload_counts"
+	// daily counters are dropped a while after their day
+	uploadCountsTTL = 48 * time.Hour
+)
+
+func startOfDay() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// initUsage creates the storage counter of a user from the files they own,
+// for users who uploaded before files were counted.
+func initUsage(userId primitive.ObjectID) error {
+	files, _ := db.GetCollection("files")
+
+	cursor, err := files.Aggregate(context.TODO(), bson.A{
+		bson.M{"$match": bson.M{"owners": userId, "status": bson.M{"$ne": types.FileQuarantined}}},
+		bson.M{"$group": bson.M{"_id": nil, "used_bytes": bson.M{"$sum": "$size"}}},
+	})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to calculate storage usage")
+	}
+
+	var totals []struct {
+		UsedBytes int64 `bson:"used_bytes"`
+	}
+	if err := cursor.All(context.TODO(), &totals); err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to calculate storage usage")
+	}
+	var used int64
+	if len(totals) > 0 {
+		used = totals[0].UsedBytes
+	}
+
+	collection, _ := db.GetCollection(usageCollection)
+	_, err = collection.UpdateOne(context.TODO(),
+		bson.M{"_id": userId},
+		bson.M{"$setOnInsert": bson.M{"used_bytes": used}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		sentry.SentryHandler(err)
+		return errors.New("failed to calculate storage usage")
+	}
+
+	return nil
+}
+
+// GetUsage returns the bytes the user's files take up and the uploads they
+// made since midnight UTC. Deduplicated files count towards every owner and
+// every upload counts, whether its content was stored already or not.
+func GetUsage(userId primitive.ObjectID) (types.FileUsage, error) {
+	usage := types.FileUsage{
+		QuotaBytes:   quotaBytes(),
+		DailyUploads: dailyUploads(),
+	}
+
+	var usageCounter struct {
+		UsedBytes int64 `bson:"used_bytes"`
+	}
+	collection, _ := db.GetCollection(usageCollection)
+	err := collection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&usageCounter)
+	if err == mongo.ErrNoDocuments {
+		if err := initUsage(userId); err != nil {
+			return types.FileUsage{}, err
+		}
+		err = collection.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&usageCounter)
+	}
+	if err != nil {
+		sentry.SentryHandler(err)
+		return types.FileUsage{}, errors.New("failed to calculate storage usage")
+	}
+	usage.UsedBytes = usageCounter.UsedBytes
+
+	var uploadsCounter struct {
+		Count int64 `bson:"count"`
+	}
+	uploads, _ := db.GetCollection(uploadsCollection)
+	err = uploads.FindOne(context.TODO(), bson.M{"user_id": userId, "day": startOfDay()}).Decode(&uploadsCounter)
+	if err != nil && err != mongo.ErrNoDocuments {
+		sentry.SentryHandler(err)
+		return types.FileUsage{}, errors.New("failed to count uploads")
+	}
+	usage.UploadsToday = uploadsCounter.Count
+
+	return usage, nil
+}
+
+// countUpload records an upload of the user today. For limited users the
+// filter only matches below the daily limit, so once it is reached the
+// upsert collides with the existing counter instead.
+func countUpload(userId primitive.ObjectID, limited bool) error {
+	collection, _ := db.GetCollection(uploadsCollection)
+
+	day := startOfDay()
+	filter := bson.M{"user_id": userId, "day": day}
+	if limited {
+		filter["count"] = bson.M{"$lt": dailyUploads()}
+	}
+
+	_, err := collection.UpdateOne(context.TODO(), filter,
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"expires_at": day.Add(uploadCountsTTL)},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return &UploadError{Status: http.StatusTooManyRequests, Msg: "daily upload limit reached"}
+	}
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to count upload")
+	}
+
+	return nil
+}
+
+// reserveBytes adds size to the user's storage if it still fits the quota.
+func reserveBytes(userId primitive.ObjectID, size int64, limited bool) error {
+	collection, _ := db.GetCollection(usageCollection)
+
+	filter := bson.M{"_id": userId}
+	if limited {
+		filter["used_bytes"] = bson.M{"$lte": quotaBytes() - size}
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$inc": bson.M{"used_bytes": size}})
+		if err != nil {
+			sentry.SentryHandler(err)
+			return errors.New("failed to reserve storage")
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+
+		// either the quota is used up or the user has no counter yet
+		count, err := collection.CountDocuments(context.TODO(), bson.M{"_id": userId})
+		if err != nil {
+			sentry.SentryHandler(err)
+			return errors.New("failed to reserve storage")
+		}
+		if count > 0 {
+			break
+		}
+		if err := initUsage(userId); err != nil {
+			return err
+		}
+	}
+
+	return &UploadError{Status: http.StatusForbidden, Msg: "storage quota exceeded"}
+}
+
+// ReserveQuota counts an upload and reserves storage for it. Admins are
+// counted but not limited. The reservation is given back with
+// releaseBytes when the upload doesn't end up owned by the user.
+func ReserveQuota(userId primitive.ObjectID, size int64, limited bool) error {
+	if err := countUpload(userId, limited); err != nil {
+		return err
+	}
+
+	return reserveBytes(userId, size, limited)
+}
+
+// settleBytes corrects a reservation to the bytes the file takes up in
+// storage. Images are re-encoded after the upload passed the quota check,
+// so a larger result is added without the limit.
+func settleBytes(userId primitive.ObjectID, reserved, stored int64) {
+	switch {
+	case stored > reserved:
+		// failures are reported by reserveBytes, the upload already succeeded
+		_ = reserveBytes(userId, stored-reserved, false)
+	case stored < reserved:
+		releaseBytes(userId, reserved-stored)
+	}
+}
+
+// releaseBytes gives back storage the user no longer holds.
+func releaseBytes(userId primitive.ObjectID, size int64) {
+	collection, _ := db.GetCollection(usageCollection)
+
+	_, err := collection.UpdateOne(context.TODO(),
+		bson.M{"_id": userId},
+		bson.M{"$inc": bson.M{"used_bytes": -size}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+}
@@ -0,0 +1,353 @@
+package static
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"henar-backend/db"
+	"henar-backend/internal/scheduler"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultOrphanDays = 7
+
+// RegisterFile records an uploaded file in the files collection. New files
+// have no references yet, so they count as unreferenced from the start.
+// When the same content was registered concurrently the existing entry is
+// claimed instead. The flag reports whether the uploader became an owner,
+// which is false when they already held the existing entry.
+func RegisterFile(file types.File) (types.File, bool, error) {
+	collection, _ := db.GetCollection("files")
+
+	now := time.Now()
+	if file.ID.IsZero() {
+		file.ID = primitive.NewObjectID()
+	}
+	file.CreatedAt = now
+	file.UnreferencedAt = &now
+	file.Owners = []primitive.ObjectID{file.UploadedBy}
+	file.RefCount = 1
+	if file.References == nil {
+		file.References = []types.FileReference{}
+	}
+
+	_, err := collection.InsertOne(context.TODO(), file)
+	if mongo.IsDuplicateKeyError(err) {
+		existing, added, err := ClaimFile(file.Hash, file.Purpose, file.UploadedBy)
+		if err != nil {
+			return types.File{}, false, err
+		}
+		if existing != nil {
+			return *existing, added, nil
+		}
+	}
+	if err != nil {
+		sentry.SentryHandler(err)
+		return types.File{}, false, errors.New("failed to register file")
+	}
+
+	return file, true, nil
+}
+
+// ClaimFile looks up a file by content hash and purpose and, if it exists,
+// adds the given uploader as an owner. An owner holds a single reference
+// however often they upload the same content, so one release drops it; the
+// flag is false when the user owned the file already. It returns nil when
+// no such file is stored yet.
+func ClaimFile(hash string, purpose types.UploadPurpose, userId primitive.ObjectID) (*types.File, bool, error) {
+	collection, _ := db.GetCollection("files")
+
+	filter := bson.M{"hash": hash, "purpose": purpose, "status": bson.M{"$ne": types.FileQuarantined}}
+	update := bson.M{
+		"$inc":      bson.M{"ref_count": 1},
+		"$addToSet": bson.M{"owners": userId},
+	}
+
+	var file types.File
+	added := true
+	err := collection.FindOneAndUpdate(context.TODO(),
+		bson.M{"$and": bson.A{filter, bson.M{"owners": bson.M{"$ne": userId}}}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		// either there is no such file or the user owns it already
+		added = false
+		err = collection.FindOne(context.TODO(), filter).Decode(&file)
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, false, nil
+	}
+	if err != nil {
+		sentry.SentryHandler(err)
+		return nil, false, errors.New("failed to claim file")
+	}
+
+	// a new claim restarts the orphan period of a file nothing points to
+	if len(file.References) == 0 {
+		now := time.Now()
+		_, err = collection.UpdateOne(context.TODO(),
+			bson.M{"_id": file.ID, "references": bson.M{"$size": 0}},
+			bson.M{"$set": bson.M{"unreferenced_at": now}},
+		)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return nil, false, errors.New("failed to claim file")
+		}
+		file.UnreferencedAt = &now
+	}
+
+	return &file, added, nil
+}
+
+// IsQuarantined reports whether content with this hash was flagged by the
+// malware scanner before, so it can be rejected without scanning again.
+func IsQuarantined(hash string) (bool, error) {
+	collection, _ := db.GetCollection("files")
+
+	count, err := collection.CountDocuments(context.TODO(), bson.M{
+		"hash":   hash,
+		"status": types.FileQuarantined,
+	})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return false, errors.New("failed to check file status")
+	}
+
+	return count > 0, nil
+}
+
+// ReleaseFile drops the reference the user holds on the file. The stored
+// objects are removed once no uploads and no documents refer to them and
+// true is returned in that case. Quarantined files are left for an admin
+// to delete.
+func ReleaseFile(file types.File, userId primitive.ObjectID) (bool, error) {
+	collection, _ := db.GetCollection("files")
+
+	var released types.File
+	err := collection.FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": file.ID, "owners": userId, "status": bson.M{"$ne": types.FileQuarantined}},
+		// the count follows the owners, which also heals counts that were
+		// raised by repeated uploads of one owner
+		bson.A{
+			bson.M{"$set": bson.M{"owners": bson.M{"$setDifference": bson.A{"$owners", bson.A{userId}}}}},
+			bson.M{"$set": bson.M{"ref_count": bson.M{"$size": "$owners"}}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&released)
+	if err != nil && err != mongo.ErrNoDocuments {
+		sentry.SentryHandler(err)
+		return false, errors.New("failed to release file")
+	}
+	if err == mongo.ErrNoDocuments {
+		released = file
+	} else {
+		releaseBytes(userId, released.Size)
+	}
+
+	if released.RefCount > 0 || len(released.References) > 0 {
+		return false, nil
+	}
+
+	// only delete when no one claimed the file in the meantime
+	result, err := collection.DeleteOne(context.TODO(), bson.M{
+		"_id":        released.ID,
+		"ref_count":  bson.M{"$lte": 0},
+		"references": bson.M{"$size": 0},
+		"status":     bson.M{"$ne": types.FileQuarantined},
+	})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return false, errors.New("failed to delete file")
+	}
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	return true, deleteObjects(released.Keys)
+}
+
+// SetReferences makes the given document field reference exactly the files
+// behind urls: files it no longer points to lose the reference and files
+// left without any reference are marked as unreferenced.
+func SetReferences(ref types.FileReference, urls []string) error {
+	collection, _ := db.GetCollection("files")
+
+	var used []string
+	for _, url := range urls {
+		if url != "" {
+			used = append(used, url)
+		}
+	}
+	if used == nil {
+		used = []string{}
+	}
+
+	_, err := collection.UpdateMany(context.TODO(),
+		bson.M{"references": ref, "urls": bson.M{"$nin": used}},
+		bson.M{"$pull": bson.M{"references": ref}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to release file references")
+	}
+
+	if len(used) > 0 {
+		_, err = collection.UpdateMany(context.TODO(),
+			bson.M{"urls": bson.M{"$in": used}},
+			bson.M{
+				"$addToSet": bson.M{"references": ref},
+				"$unset":    bson.M{"unreferenced_at": ""},
+			},
+		)
+		if err != nil {
+			sentry.SentryHandler(err)
+			return errors.New("failed to add file references")
+		}
+	}
+
+	_, err = collection.UpdateMany(context.TODO(),
+		bson.M{"references": bson.M{"$size": 0}, "unreferenced_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"unreferenced_at": time.Now()}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to mark unreferenced files")
+	}
+
+	return nil
+}
+
+// ReleaseReferences drops every reference held by the document, used when
+// the document itself is deleted.
+func ReleaseReferences(collectionName string, documentId primitive.ObjectID) error {
+	collection, _ := db.GetCollection("files")
+
+	_, err := collection.UpdateMany(context.TODO(),
+		bson.M{"references": bson.M{"$elemMatch": bson.M{"collection": collectionName, "document": documentId}}},
+		bson.M{"$pull": bson.M{"references": bson.M{"collection": collectionName, "document": documentId}}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to release file references")
+	}
+
+	_, err = collection.UpdateMany(context.TODO(),
+		bson.M{"references": bson.M{"$size": 0}, "unreferenced_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"unreferenced_at": time.Now()}},
+	)
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to mark unreferenced files")
+	}
+
+	return nil
+}
+
+func deleteObjects(keys []string) error {
+	for _, key := range keys {
+		_, err := s3Client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			sentry.SentryHandler(err)
+			return fmt.Errorf("failed to delete object %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// removeFile deletes the registry entry of a file and its stored objects.
+func removeFile(file types.File) error {
+	collection, _ := db.GetCollection("files")
+	_, err := collection.DeleteOne(context.TODO(), bson.M{"_id": file.ID})
+	if err != nil {
+		sentry.SentryHandler(err)
+		return errors.New("failed to delete file")
+	}
+
+	if file.Status != types.FileQuarantined {
+		for _, owner := range file.Owners {
+			releaseBytes(owner, file.Size)
+		}
+	}
+
+	return deleteObjects(file.Keys)
+}
+
+// orphanFilter narrows the filter to files nothing has referenced since
+// before the cutoff, whoever still owns them. Quarantined files are kept
+// until an admin deletes them.
+func orphanFilter(filter bson.M, cutoff time.Time) bson.M {
+	filter["references"] = bson.M{"$size": 0}
+	filter["unreferenced_at"] = bson.M{"$lt": cutoff}
+	filter["status"] = bson.M{"$ne": types.FileQuarantined}
+
+	return filter
+}
+
+// SweepOrphans removes files that have been unreferenced for longer than
+// the retention period. Uploads that were never attached to anything are
+// removed as well, and their size is given back to the owners' quota.
+func SweepOrphans(ctx context.Context, retention time.Duration) error {
+	collection, _ := db.GetCollection("files")
+	cutoff := time.Now().Add(-retention)
+
+	cursor, err := collection.Find(ctx, orphanFilter(bson.M{}, cutoff))
+	if err != nil {
+		return fmt.Errorf("failed to find orphan files: %w", err)
+	}
+
+	var files []types.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return fmt.Errorf("failed to decode orphan files: %w", err)
+	}
+
+	for _, file := range files {
+		// the file may have been claimed or referenced since it was found
+		var deleted types.File
+		err := collection.FindOneAndDelete(ctx, orphanFilter(bson.M{"_id": file.ID}, cutoff)).Decode(&deleted)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete orphan file %s: %w", file.ID.Hex(), err)
+		}
+
+		for _, owner := range deleted.Owners {
+			releaseBytes(owner, deleted.Size)
+		}
+		if err := deleteObjects(deleted.Keys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartSweeper runs SweepOrphans once a day. The retention period in days is
+// read from FILES_ORPHAN_DAYS.
+func StartSweeper(ctx context.Context) {
+	days, err := strconv.Atoi(os.Getenv("FILES_ORPHAN_DAYS"))
+	if err != nil || days <= 0 {
+		days = defaultOrphanDays
+	}
+	retention := time.Duration(days) * 24 * time.Hour
+
+	scheduler.Every(ctx, "files-sweeper", 24*time.Hour, func(ctx context.Context) error {
+		return SweepOrphans(ctx, retention)
+	})
+}
@@ -0,0 +1,61 @@
+package static
+
+import (
+	"bytes"
+	"context"
+	"henar-backend/sentry"
+	"henar-backend/types"
+	"net/http"
+	"time"
+)
+
+const scanTimeout = 30 * time.Second
+
+// scanFile runs the configured malware scanner over the content and sets
+// the file status. Scanner failures reject the upload instead of letting
+// unscanned content through.
+func scanFile(record types.File, data []byte) (types.File, error) {
+	if !scanEnabled {
+		record.Status = types.FileUnscanned
+		return record, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+
+	result, err := fileScanner.Scan(ctx, bytes.NewReader(data))
+	if err != nil {
+		sentry.SentryHandler(err)
+		return types.File{}, &UploadError{Status: http.StatusServiceUnavailable, Msg: "file scanning is unavailable"}
+	}
+
+	if result.Clean {
+		record.Status = types.FileClean
+		return record, nil
+	}
+
+	record.Status = types.FileQuarantined
+	record.ScanSignature = result.Signature
+
+	return record, nil
+}
+
+// quarantineFile keeps flagged content in a private prefix, unprocessed, so
+// admins can review it without it ever being served publicly.
+func quarantineFile(record types.File, data []byte) (types.File, error) {
+	key := "quarantine/" + record.Hash + extensionFor(record.ContentType)
+
+	err := putObject(key, bytes.NewReader(data), record.ContentType, true)
+	if err != nil {
+		return types.File{}, err
+	}
+
+	record.Private = true
+	record.Keys = []string{key}
+	record.Objects = map[string]string{"original": key}
+	record.URL = privateURL(record.ID, "original")
+	record.URLs = []string{record.URL}
+
+	record, _, err = RegisterFile(record)
+	return record, err
+}
@@ -2,8 +2,10 @@ package types
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gopkg.in/go-playground/validator.v9"
 )
@@ -74,6 +76,8 @@ type UserProjects struct {
 type UserBody struct {
 	Banned          bool                        `json:"banned" bson:"banned,omitempty"`
 	Avatar          string                      `json:"avatar"`
+	CV              string                      `json:"cv" bson:"cv,omitempty"`
+	Licence         string                      `json:"licence" bson:"licence,omitempty"`
 	FirstName       string                      `json:"first_name" bson:"first_name,omitempty"`
 	LastName        string                      `json:"last_name" bson:"last_name,omitempty"`
 	Description     string                      `json:"description"`
@@ -83,7 +87,6 @@ type UserBody struct {
 	Job             string                      `json:"job"`
 	Language        string                      `json:"language,omitempty"`
 	Tags            []primitive.ObjectID        `json:"tags"`
-	Notifications   []primitive.ObjectID        `json:"notifications,omitempty" bson:"notifications,omitempty"`
 	Events          map[primitive.ObjectID]bool `json:"events" bson:"events"`
 	Researches      map[primitive.ObjectID]bool `json:"researches" bson:"researches"`
 	ContactsRequest `json:"contacts_request" bson:"contacts_request"`
@@ -91,13 +94,45 @@ type UserBody struct {
 }
 
 type User struct {
-	ID              primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
-	IsActivated     bool               `json:"-" bson:"is_activated"`
-	IsEmailVerified *bool              `json:"-" bson:"is_email_verified"`
+	ID                      primitive.ObjectID      `json:"_id" bson:"_id,omitempty"`
+	IsActivated             bool                    `json:"-" bson:"is_activated"`
+	IsEmailVerified         *bool                   `json:"-" bson:"is_email_verified"`
+	EmailDeliverability     *EmailDeliverability    `json:"-" bson:"email_deliverability,omitempty"`
+	NotificationPreferences NotificationPreferences `json:"-" bson:"notification_preferences,omitempty"`
+	DigestFrequency         DigestFrequency         `json:"-" bson:"digest_frequency,omitempty"`
+	LastDigestAt            *time.Time              `json:"-" bson:"last_digest_at,omitempty"`
+	// CalendarToken is the secret in the URL of the user's personal calendar
+	CalendarToken   string `json:"-" bson:"calendar_token,omitempty"`
 	UserCredentials `bson:"user_credentials"`
 	UserBody        `bson:"user_body"`
 }
 
+type EmailStatus string
+
+const (
+	EmailSoftBounced EmailStatus = "soft_bounced"
+	EmailHardBounced EmailStatus = "hard_bounced"
+	EmailComplained  EmailStatus = "spam_complaint"
+	EmailBlocked     EmailStatus = "blocked"
+)
+
+// EmailDeliverability is the last delivery problem the email provider
+// reported for a user's address.
+type EmailDeliverability struct {
+	Status      EmailStatus `json:"status" bson:"status"`
+	Reason      string      `json:"reason,omitempty" bson:"reason,omitempty"`
+	SoftBounces int         `json:"soft_bounces" bson:"soft_bounces"`
+	UpdatedAt   time.Time   `json:"updated_at" bson:"updated_at"`
+}
+
+type UndeliverableUser struct {
+	ID                  primitive.ObjectID  `json:"_id" bson:"_id"`
+	Email               string              `json:"email" bson:"email"`
+	FirstName           string              `json:"first_name" bson:"first_name"`
+	LastName            string              `json:"last_name" bson:"last_name"`
+	EmailDeliverability EmailDeliverability `json:"email_deliverability" bson:"email_deliverability"`
+}
+
 type NotificationStatus string
 
 const (
@@ -115,8 +150,159 @@ const (
 	ProjectApproved         NotificationType = "project_approved"
 	ProjetcDeclined         NotificationType = "project_declined"
 	NewComment              NotificationType = "new_comment"
+	EventWaitlistPromoted   NotificationType = "event_waitlist_promoted"
+	EventReminder           NotificationType = "event_reminder"
+	EventCancelled          NotificationType = "event_cancelled"
+)
+
+var NotificationTypes = []NotificationType{
+	ApproveApplicant,
+	ContactsRequested,
+	ContactsRequestApproved,
+	ProjectRequest,
+	ProjectApproved,
+	ProjetcDeclined,
+	NewComment,
+	EventWaitlistPromoted,
+	EventReminder,
+	EventCancelled,
+}
+
+// IsAggregated reports whether notifications of this type about the same
+// target are merged into one listing every actor.
+func (t NotificationType) IsAggregated() bool {
+	switch t {
+	case ContactsRequested, ContactsRequestApproved, ProjectRequest, NewComment:
+		return true
+	}
+
+	return false
+}
+
+func (t NotificationType) IsValid() bool {
+	for _, notificationType := range NotificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NotificationChannel is where a user receives one type of notification.
+// Channels are combined with commas, e.g. "in_app,push"; "both" is short
+// for in-app and email.
+type NotificationChannel string
+
+const (
+	InAppChannel NotificationChannel = "in_app"
+	EmailChannel NotificationChannel = "email"
+	PushChannel  NotificationChannel = "push"
+	BothChannels NotificationChannel = "both"
+	NoChannel    NotificationChannel = "none"
+)
+
+// Channels combines single channels into one preference.
+func Channels(channels ...NotificationChannel) NotificationChannel {
+	parts := make([]string, len(channels))
+	for i, channel := range channels {
+		parts[i] = string(channel)
+	}
+
+	return NotificationChannel(strings.Join(parts, ","))
+}
+
+func (c NotificationChannel) channels() []NotificationChannel {
+	switch c {
+	case BothChannels:
+		return []NotificationChannel{InAppChannel, EmailChannel}
+	case NoChannel:
+		return nil
+	}
+
+	var channels []NotificationChannel
+	for _, part := range strings.Split(string(c), ",") {
+		channels = append(channels, NotificationChannel(part))
+	}
+
+	return channels
+}
+
+func (c NotificationChannel) has(channel NotificationChannel) bool {
+	for _, ch := range c.channels() {
+		if ch == channel {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c NotificationChannel) IsValid() bool {
+	if c == BothChannels || c == NoChannel {
+		return true
+	}
+
+	for _, channel := range c.channels() {
+		switch channel {
+		case InAppChannel, EmailChannel, PushChannel:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c NotificationChannel) InApp() bool {
+	return c.has(InAppChannel)
+}
+
+func (c NotificationChannel) Email() bool {
+	return c.has(EmailChannel)
+}
+
+func (c NotificationChannel) Push() bool {
+	return c.has(PushChannel)
+}
+
+type NotificationPreferences map[NotificationType]NotificationChannel
+
+// DigestFrequency is how often a user gets an email summing up their unread
+// notifications.
+type DigestFrequency string
+
+const (
+	DigestOff    DigestFrequency = "off"
+	DigestDaily  DigestFrequency = "daily"
+	DigestWeekly DigestFrequency = "weekly"
 )
 
+func (f DigestFrequency) IsValid() bool {
+	switch f {
+	case DigestOff, DigestDaily, DigestWeekly:
+		return true
+	}
+
+	return false
+}
+
+func (f DigestFrequency) Period() time.Duration {
+	switch f {
+	case DigestDaily:
+		return 24 * time.Hour
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	}
+
+	return 0
+}
+
+type DigestSettings struct {
+	Frequency    DigestFrequency `json:"frequency"`
+	LastDigestAt *time.Time      `json:"last_digest_at,omitempty"`
+}
+
 type NotificationAcceptiongRequestBody struct {
 	NotificationsIds []string `json:"notificationsIds"`
 }
@@ -127,35 +313,174 @@ type NotificationBody struct {
 	ProjectID      string             `json:"projectId" bson:"project_id"`
 	ProjectTitle   string             `json:"projectTitle" bson:"project_title"`
 	Avatar         string             `json:"avatar" bson:"avatar"`
+	EventID        string             `json:"eventId,omitempty" bson:"event_id,omitempty"`
+	EventTitle     string             `json:"eventTitle,omitempty" bson:"event_title,omitempty"`
+	EventDate      *time.Time         `json:"eventDate,omitempty" bson:"event_date,omitempty"`
 }
 type Notification struct {
-	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
-	CreatedAt time.Time          `json:"createdAt" bson:"created_at,omitempty"`
-	Status    NotificationStatus `json:"status"`
-	Type      NotificationType   `json:"type"`
-	User      primitive.ObjectID `json:"userId" bson:"user_id"`
-	Body      NotificationBody   `json:"body" bson:"body,omitempty"`
+	ID         primitive.ObjectID  `json:"_id" bson:"_id,omitempty"`
+	CreatedAt  time.Time           `json:"createdAt" bson:"created_at,omitempty"`
+	Status     NotificationStatus  `json:"status"`
+	Type       NotificationType    `json:"type"`
+	User       primitive.ObjectID  `json:"userId" bson:"user_id"`
+	Body       NotificationBody    `json:"body" bson:"body,omitempty"`
+	Actors     []NotificationActor `json:"actors,omitempty" bson:"actors,omitempty"`
+	ArchivedAt *time.Time          `json:"archivedAt,omitempty" bson:"archived_at,omitempty"`
+	ReadAt     *time.Time          `json:"readAt,omitempty" bson:"read_at,omitempty"`
+}
+
+// NotificationActor is one of the people behind an aggregated notification,
+// e.g. each applicant in "5 people applied to Project X".
+type NotificationActor struct {
+	PersonID       primitive.ObjectID `json:"personId" bson:"person_id"`
+	PersonFullName string             `json:"personFullName" bson:"person_full_name"`
+	Avatar         string             `json:"avatar" bson:"avatar"`
 }
 
 type NotificationResponse struct {
-	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
-	CreatedAt time.Time          `json:"createdAt" bson:"created_at,omitempty"`
-	Status    NotificationStatus `json:"status"`
-	Type      NotificationType   `json:"type"`
-	Body      NotificationBody   `json:"body" bson:"body,omitempty"`
+	ID         primitive.ObjectID  `json:"_id" bson:"_id,omitempty"`
+	CreatedAt  time.Time           `json:"createdAt" bson:"created_at,omitempty"`
+	Status     NotificationStatus  `json:"status"`
+	Type       NotificationType    `json:"type"`
+	Body       NotificationBody    `json:"body" bson:"body,omitempty"`
+	Actors     []NotificationActor `json:"actors,omitempty" bson:"actors,omitempty"`
+	ArchivedAt *time.Time          `json:"archivedAt,omitempty" bson:"archived_at,omitempty"`
+	// Text is the notification rendered in the reader's language with
+	// current names and titles, Body keeps what was stored
+	Text string `json:"text" bson:"-"`
+}
+
+// NotificationsPage is one page of a user's notifications, newest first.
+// NextCursor is passed as the before parameter to get the following page.
+type NotificationsPage struct {
+	Notifications []NotificationResponse `json:"notifications"`
+	NextCursor    string                 `json:"nextCursor,omitempty"`
+}
+
+// PushSubscription is one browser's Web Push subscription. A user has one
+// per device they enabled push notifications on.
+type PushSubscription struct {
+	ID        primitive.ObjectID   `json:"_id" bson:"_id,omitempty"`
+	User      primitive.ObjectID   `json:"userId" bson:"user_id"`
+	Endpoint  string               `json:"endpoint" bson:"endpoint" validate:"required,url"`
+	Keys      PushSubscriptionKeys `json:"keys" bson:"keys"`
+	UserAgent string               `json:"userAgent,omitempty" bson:"user_agent,omitempty"`
+	CreatedAt time.Time            `json:"createdAt" bson:"created_at"`
+}
+
+type PushSubscriptionKeys struct {
+	P256dh string `json:"p256dh" bson:"p256dh" validate:"required"`
+	Auth   string `json:"auth" bson:"auth" validate:"required"`
+}
+
+type PushUnsubscribeRequestBody struct {
+	Endpoint string `json:"endpoint" validate:"required"`
 }
 
 type Event struct {
-	ID          primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
-	CreatedBy   primitive.ObjectID `json:"created_by" bson:"created_by,omitempty"`
-	Slug        string             `json:"slug"`
-	Cover       string             `json:"cover"`
-	Title       Translations       `json:"title"`
-	Description Translations       `json:"description"`
-	Orgs        string             `json:"orgs"`
-	Location    primitive.ObjectID `json:"location" bson:"location,omitempty"`
-	Date        time.Time          `json:"date" validate:"required"`
-	Links       string             `json:"links" bson:"terms_of_visit"`
+	ID          primitive.ObjectID   `json:"_id" bson:"_id,omitempty"`
+	CreatedBy   primitive.ObjectID   `json:"created_by" bson:"created_by,omitempty"`
+	Slug        string               `json:"slug"`
+	Cover       string               `json:"cover"`
+	Title       Translations         `json:"title"`
+	Description Translations         `json:"description"`
+	Orgs        string               `json:"orgs"`
+	Location    primitive.ObjectID   `json:"location" bson:"location,omitempty"`
+	Date        time.Time            `json:"date" validate:"required"`
+	Links       string               `json:"links" bson:"terms_of_visit"`
+	Tags        []primitive.ObjectID `json:"tags" bson:"tags,omitempty"`
+	// Capacity limits how many people can go, 0 or unset means unlimited.
+	// Registrations beyond it are waitlisted.
+	Capacity *int `json:"capacity,omitempty" bson:"capacity,omitempty" validate:"omitempty,min=0"`
+	// GoingCount is maintained by registrations and ignored in requests
+	GoingCount int `json:"going_count" bson:"going_count,omitempty"`
+	// Recurrence makes the event the first occurrence of a series
+	Recurrence *Recurrence `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	// SeriesID and OriginalDate are set on occurrences of a series: on
+	// occurrences expanded from the rule and on single occurrences that were
+	// edited and are stored on their own
+	SeriesID     *primitive.ObjectID `json:"series_id,omitempty" bson:"series_id,omitempty"`
+	OriginalDate *time.Time          `json:"original_date,omitempty" bson:"original_date,omitempty"`
+	// Certificates lets the attendees who checked in download a
+	// certificate of attendance
+	Certificates *bool `json:"certificates,omitempty" bson:"certificates,omitempty"`
+}
+
+// Recurrence repeats an event by an RFC 5545 rule, e.g.
+// FREQ=MONTHLY;COUNT=6 or FREQ=WEEKLY;BYDAY=MO,TH;UNTIL=20261231. Daily,
+// weekly and monthly rules with INTERVAL, COUNT, UNTIL and weekly BYDAY are
+// supported.
+type Recurrence struct {
+	RRule string `json:"rrule" bson:"rrule" validate:"required"`
+	// ExDates are the cancelled occurrences
+	ExDates []time.Time `json:"exdates,omitempty" bson:"exdates,omitempty"`
+}
+
+type RSVPStatus string
+
+const (
+	RSVPGoing      RSVPStatus = "going"
+	RSVPInterested RSVPStatus = "interested"
+	// going, but the event was full
+	RSVPWaitlisted RSVPStatus = "waitlisted"
+)
+
+// EventRegistration is one user's RSVP to an event.
+type EventRegistration struct {
+	ID          primitive.ObjectID  `json:"_id" bson:"_id,omitempty"`
+	Event       primitive.ObjectID  `json:"eventId" bson:"event_id"`
+	User        primitive.ObjectID  `json:"userId" bson:"user_id"`
+	Status      RSVPStatus          `json:"status" bson:"status"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"created_at"`
+	UpdatedAt   time.Time           `json:"updatedAt" bson:"updated_at"`
+	PromotedAt  *time.Time          `json:"promotedAt,omitempty" bson:"promoted_at,omitempty"`
+	CheckedInAt *time.Time          `json:"checkedInAt,omitempty" bson:"checked_in_at,omitempty"`
+	CheckedInBy *primitive.ObjectID `json:"checkedInBy,omitempty" bson:"checked_in_by,omitempty"`
+}
+
+type RSVPRequestBody struct {
+	Status RSVPStatus `json:"status" validate:"required,oneof=going interested"`
+}
+
+// CheckInRequestBody carries the token read from an attendee's QR code.
+type CheckInRequestBody struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// Certificate is a certificate of attendance issued to a user who checked
+// in to an event. The name, title and organizer are kept as issued, so the
+// certificate verifies even after the event changes or is deleted.
+type Certificate struct {
+	ID         primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Code       string             `json:"code" bson:"code"`
+	Event      primitive.ObjectID `json:"eventId" bson:"event_id"`
+	User       primitive.ObjectID `json:"-" bson:"user_id"`
+	Name       string             `json:"name" bson:"name"`
+	EventTitle Translations       `json:"eventTitle" bson:"event_title"`
+	EventDate  time.Time          `json:"eventDate" bson:"event_date"`
+	Organizer  string             `json:"organizer" bson:"organizer"`
+	IssuedAt   time.Time          `json:"issuedAt" bson:"issued_at"`
+}
+
+// AttendanceReport sums up who came to an event out of those going.
+type AttendanceReport struct {
+	Going     int             `json:"going"`
+	CheckedIn int             `json:"checkedIn"`
+	Attendees []EventAttendee `json:"attendees"`
+}
+
+// EventAttendee is a registration listed to the event's organizers.
+type EventAttendee struct {
+	EventRegistration `bson:",inline"`
+	FirstName         string `json:"first_name" bson:"first_name"`
+	LastName          string `json:"last_name" bson:"last_name"`
+	Avatar            string `json:"avatar" bson:"avatar"`
+}
+
+// UserEvent is an event together with the current user's RSVP.
+type UserEvent struct {
+	Event        `bson:",inline"`
+	Registration EventRegistration `json:"registration" bson:"registration"`
 }
 
 type ModerationStatus string
@@ -300,9 +625,11 @@ type Project struct {
 	Applicants           map[primitive.ObjectID]bool `json:"applicants" bson:"applicants,omitempty"`
 	SuccessfulApplicants map[primitive.ObjectID]bool `json:"successful_applicants" bson:"successful_applicants,omitempty"`
 	RejectedApplicants   map[primitive.ObjectID]bool `json:"rejected_applicants" bson:"rejected_applicants,omitempty"`
-	Links                string                      `json:"links" bson:"links,omitempty"`
-	Request              string                      `json:"request" bson:"request,omitempty"`
-	Phase                string                      `json:"phase" bson:"phase,omitempty"`
+	// ApplicationAttachments is the private file each applicant sent along
+	ApplicationAttachments map[primitive.ObjectID]string `json:"application_attachments,omitempty" bson:"application_attachments,omitempty"`
+	Links                  string                        `json:"links" bson:"links,omitempty"`
+	Request                string                        `json:"request" bson:"request,omitempty"`
+	Phase                  string                        `json:"phase" bson:"phase,omitempty"`
 }
 
 type Research struct {
@@ -364,8 +691,111 @@ type Translations struct {
 	Hy string `bson:"hy" json:"hy"`
 }
 
+// Localized returns the text in the given language, falling back to English
+// when it was not translated.
+func (t Translations) Localized(language string) string {
+	switch {
+	case language == "ru" && t.Ru != "":
+		return t.Ru
+	case language == "hy" && t.Hy != "":
+		return t.Hy
+	}
+
+	return t.En
+}
+
 type FileResponce struct {
-	URL string `bson:"en" json:"url"`
+	ID       primitive.ObjectID `json:"id"`
+	URL      string             `bson:"en" json:"url"`
+	Variants map[string]string  `json:"variants,omitempty"`
+	Private  bool               `json:"private,omitempty"`
+}
+
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type FileStatus string
+
+const (
+	FileUnscanned   FileStatus = "unscanned"
+	FileClean       FileStatus = "clean"
+	FileQuarantined FileStatus = "quarantined"
+)
+
+type FileReference struct {
+	Collection string             `json:"collection" bson:"collection"`
+	Document   primitive.ObjectID `json:"document" bson:"document"`
+	Field      string             `json:"field" bson:"field"`
+}
+
+type File struct {
+	ID             primitive.ObjectID   `json:"_id" bson:"_id,omitempty"`
+	UploadedBy     primitive.ObjectID   `json:"uploaded_by" bson:"uploaded_by"`
+	Owners         []primitive.ObjectID `json:"owners" bson:"owners"`
+	RefCount       int                  `json:"ref_count" bson:"ref_count"`
+	Purpose        UploadPurpose        `json:"purpose" bson:"purpose"`
+	ContentType    string               `json:"content_type" bson:"content_type"`
+	Size           int64                `json:"size" bson:"size"`
+	Hash           string               `json:"hash" bson:"hash"`
+	Keys           []string             `json:"-" bson:"keys"`
+	Objects        map[string]string    `json:"-" bson:"objects"`
+	Private        bool                 `json:"private" bson:"private"`
+	Status         FileStatus           `json:"status" bson:"status"`
+	ScanSignature  string               `json:"scan_signature,omitempty" bson:"scan_signature,omitempty"`
+	URL            string               `json:"url" bson:"url"`
+	Variants       map[string]string    `json:"variants,omitempty" bson:"variants,omitempty"`
+	URLs           []string             `json:"urls" bson:"urls"`
+	References     []FileReference      `json:"references" bson:"references"`
+	CreatedAt      time.Time            `json:"created_at" bson:"created_at"`
+	UnreferencedAt *time.Time           `json:"unreferenced_at,omitempty" bson:"unreferenced_at,omitempty"`
+}
+
+type FileUsage struct {
+	UsedBytes    int64 `json:"used_bytes"`
+	QuotaBytes   int64 `json:"quota_bytes"`
+	UploadsToday int64 `json:"uploads_today"`
+	DailyUploads int64 `json:"daily_uploads"`
+	Unlimited    bool  `json:"unlimited"`
+}
+
+type UploadPurpose string
+
+const (
+	AvatarUpload       UploadPurpose = "avatar"
+	ProjectCoverUpload UploadPurpose = "project_cover"
+	EventCoverUpload   UploadPurpose = "event_cover"
+	AttachmentUpload   UploadPurpose = "attachment"
+)
+
+func (s *UploadPurpose) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "avatar":
+		*s = AvatarUpload
+	case "project_cover":
+		*s = ProjectCoverUpload
+	case "event_cover":
+		*s = EventCoverUpload
+	case "attachment":
+		*s = AttachmentUpload
+	default:
+		return fmt.Errorf("unknown upload purpose: %q", text)
+	}
+	return nil
+}
+
+func (s UploadPurpose) MarshalText() ([]byte, error) {
+	return []byte(s), nil
+}
+
+func (s UploadPurpose) IsValid() bool {
+	switch s {
+	case AvatarUpload, ProjectCoverUpload, EventCoverUpload, AttachmentUpload:
+		return true
+	}
+
+	return false
 }
 
 type VerificationDataType string
@@ -391,3 +821,54 @@ type ResendVerificationEmail struct {
 	Token string `json:"token,omitempty" validate:"omitempty,hexadecimal"`
 	Email string `json:"email,omitempty" validate:"omitempty,email"`
 }
+
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending"
+	OutboxSending OutboxStatus = "sending"
+	OutboxSent    OutboxStatus = "sent"
+	OutboxFailed  OutboxStatus = "failed"
+	// the recipient's address hard bounced or reported spam before
+	OutboxSuppressed OutboxStatus = "suppressed"
+)
+
+type OutboxEmail struct {
+	ID            primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Key           string             `json:"key" bson:"key"`
+	To            string             `json:"to" bson:"to"`
+	ToName        string             `json:"to_name" bson:"to_name"`
+	Subject       string             `json:"subject" bson:"subject"`
+	Text          string             `json:"-" bson:"text"`
+	HTML          string             `json:"-" bson:"html"`
+	Status        OutboxStatus       `json:"status" bson:"status"`
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	LastError     string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt time.Time          `json:"next_attempt_at" bson:"next_attempt_at"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	SentAt        *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
+
+type ScheduledJobStatus string
+
+const (
+	JobPending ScheduledJobStatus = "pending"
+	JobRunning ScheduledJobStatus = "running"
+	JobDone    ScheduledJobStatus = "done"
+	JobFailed  ScheduledJobStatus = "failed"
+)
+
+// ScheduledJob is a one-off background job that runs at RunAt. Key
+// identifies the job, scheduling the same key again moves it.
+type ScheduledJob struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Key       string             `json:"key" bson:"key"`
+	Kind      string             `json:"kind" bson:"kind"`
+	Payload   bson.Raw           `json:"-" bson:"payload,omitempty"`
+	Status    ScheduledJobStatus `json:"status" bson:"status"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	LastError string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	RunAt     time.Time          `json:"run_at" bson:"run_at"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	DoneAt    *time.Time         `json:"done_at,omitempty" bson:"done_at,omitempty"`
+}
@@ -6,6 +6,7 @@ import (
 	"henar-backend/db"
 	"henar-backend/notifications"
 	"henar-backend/sentry"
+	"henar-backend/static"
 	"henar-backend/types"
 	"henar-backend/utils"
 	"net/http"
@@ -100,7 +101,7 @@ func CreateUser(c *fiber.Ctx) error {
 	result, err := collection.InsertOne(context.TODO(), user)
 	if err != nil {
 		sentry.SentryHandler(err)
-		return fmt.Errorf("Error creating user: ", err)
+		return fmt.Errorf("Error creating user: %w", err)
 	}
 
 	// Get the ID of the inserted user document
@@ -112,7 +113,7 @@ func CreateUser(c *fiber.Ctx) error {
 	err = collection.FindOne(context.TODO(), filter).Decode(&createdUser)
 	if err != nil {
 		sentry.SentryHandler(err)
-		return fmt.Errorf("Error retrieving created user: ", err)
+		return fmt.Errorf("Error retrieving created user: %w", err)
 	}
 	createdUser.Password = nil
 
@@ -196,6 +197,22 @@ func UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	cvRef := types.FileReference{Collection: "users", Document: objId, Field: "cv"}
+	licenceRef := types.FileReference{Collection: "users", Document: objId, Field: "licence"}
+	attachments := []struct {
+		ref types.FileReference
+		url string
+	}{{cvRef, updateBody.CV}, {licenceRef, updateBody.Licence}}
+	for _, attachment := range attachments {
+		allowed, err := static.CanReference(attachment.ref, objId, []string{attachment.url})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).SendString("Error checking attachments: " + err.Error())
+		}
+		if !allowed {
+			return c.Status(http.StatusBadRequest).SendString("The " + attachment.ref.Field + " must be a file uploaded by the user")
+		}
+	}
+
 	if userRole != "admin" {
 		specialist := types.Specialist
 		updateBody.Role = &specialist
@@ -224,6 +241,20 @@ func UpdateUser(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).SendString("Error retrieving updated user: " + err.Error())
 	}
 
+	avatarRef := types.FileReference{Collection: "users", Document: objId, Field: "avatar"}
+	err = static.SetReferences(avatarRef, []string{updatedUser.Avatar})
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+	err = static.SetReferences(cvRef, []string{updatedUser.CV})
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+	err = static.SetReferences(licenceRef, []string{updatedUser.Licence})
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	fieldsToUpdate := []string{"Password"}
 	utils.UpdateResultForUserRole(&updatedUser, fieldsToUpdate)
 
@@ -437,6 +468,11 @@ func DeleteUser(c *fiber.Ctx) error {
 		return c.Status(http.StatusNotFound).SendString("User not found")
 	}
 
+	err = static.ReleaseReferences("users", objId)
+	if err != nil {
+		sentry.SentryHandler(err)
+	}
+
 	// Set the response headers and write the response body
 	return c.SendString("User deleted successfully")
 }